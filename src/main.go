@@ -9,10 +9,17 @@ import (
 	"./websocket"
 )
 
-func handleConnection(conn websocket.Conn) {
+func handleConnection(conn *websocket.Conn) {
+	// Drop clients that stop answering pings, e.g. behind a NAT
+	conn.SetKeepAlive(30*time.Second, 10*time.Second)
+
 	for true {
 		opcode, message, err := conn.Receive()
 
+		if websocket.IsUnexpectedCloseError(err, websocket.CloseStatusNormal, websocket.CloseStatusGoingAway) {
+			fmt.Println("Client left unexpectedly", err)
+		}
+
 		if err != nil {
 			fmt.Println("Failed to receive message", err)
 			return
@@ -45,7 +52,7 @@ func runServer() error {
 	return nil
 }
 
-func readClient(conn websocket.Conn) {
+func readClient(conn *websocket.Conn) {
 	for true {
 		opcode, msg, err := conn.Receive()
 
@@ -0,0 +1,33 @@
+package websocket
+
+// BufferPool is a pool of write buffers shared by connections, e.g. a
+// *sync.Pool. The pool holds *[]byte values, Get returns nil when the pool
+// is empty and a new buffer is allocated.
+//
+// A connection with a write buffer pool takes a buffer from the pool for
+// every frame it writes and returns it once the frame is written to the
+// network connection, so idle connections hold no write buffer.
+type BufferPool interface {
+	Get() interface{}
+	Put(interface{})
+}
+
+// defaultWriteBufferSize is the size of the write buffers allocated when a
+// pool is empty
+const defaultWriteBufferSize = 4096
+
+// getWriteBuffer returns a buffer from pool, or a new buffer of size bytes
+// when the pool is empty
+func getWriteBuffer(pool BufferPool, size int) *[]byte {
+	if buf, ok := pool.Get().(*[]byte); ok && buf != nil {
+		return buf
+	}
+
+	if size <= 0 {
+		size = defaultWriteBufferSize
+	}
+
+	b := make([]byte, size)
+
+	return &b
+}
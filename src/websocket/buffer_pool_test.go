@@ -0,0 +1,270 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingPool is a write buffer pool that counts the buffers taken and
+// returned
+type countingPool struct {
+	pool       sync.Pool
+	gets, puts int32
+}
+
+func (p *countingPool) Get() interface{} {
+	atomic.AddInt32(&p.gets, 1)
+	return p.pool.Get()
+}
+
+func (p *countingPool) Put(b interface{}) {
+	atomic.AddInt32(&p.puts, 1)
+	p.pool.Put(b)
+}
+
+func TestWriteBufferPool(t *testing.T) {
+	serverPool, clientPool := &countingPool{}, &countingPool{}
+
+	upgrader := &Upgrader{WriteBufferSize: 1024, WriteBufferPool: serverPool}
+	done := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		defer conn.rwc.Close()
+
+		// Idle connections hold no write buffer
+		assert.Nil(t, conn.brw.Writer)
+
+		opcode, message, err := conn.Receive()
+
+		if assert.Nil(t, err) {
+			conn.Send(opcode, message)
+		}
+	}))
+	defer server.Close()
+
+	dialer := &Dialer{WriteBufferPool: clientPool}
+
+	ws, err := dialer.Dial(wsURL(server))
+
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	defer ws.rwc.Close()
+
+	assert.Nil(t, ws.brw.Writer)
+
+	// Larger than a pooled buffer
+	message := bytes.Repeat([]byte("pooled "), 1000)
+
+	assert.Nil(t, ws.Send(BinaryMessage, message))
+
+	_, echo, err := ws.Receive()
+
+	assert.Nil(t, err)
+	assert.Equal(t, message, echo)
+
+	<-done
+
+	// Every buffer taken for a frame is returned
+	for _, pool := range []*countingPool{serverPool, clientPool} {
+		assert.Equal(t, int32(1), atomic.LoadInt32(&pool.gets))
+		assert.Equal(t, atomic.LoadInt32(&pool.gets), atomic.LoadInt32(&pool.puts))
+	}
+}
+
+func TestWriteLeavesPayload(t *testing.T) {
+	src, ws, err := wsPipe(nil)
+
+	assert.Nil(t, err)
+
+	payload := bytes.Repeat([]byte("masked"), 1000)
+	original := append([]byte(nil), payload...)
+
+	go ws.Send(BinaryMessage, payload)
+
+	frames, err := readRawFrames(src)
+
+	if assert.Nil(t, err) {
+		assert.Equal(t, original, frames[0].payload)
+		assert.Equal(t, original, payload)
+	}
+}
+
+func TestReceiveInto(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	go func() {
+		writeTestFrame(src, true, true, TextMessage, []byte("hello"))
+		writeTestFrame(src, true, true, BinaryMessage, []byte("12345678"))
+		writeTestFrame(src, true, false, TextMessage, []byte("fragmented "))
+		writeTestFrame(src, true, true, ContinuationFrame, []byte("message"))
+		writeTestFrame(src, true, true, BinaryMessage, nil)
+	}()
+
+	dst := make([]byte, 3, 8)
+
+	// The message replaces dst's contents in its backing array
+	opcode, message, err := ws.ReceiveInto(dst)
+
+	assert.Nil(t, err)
+	assert.Equal(t, byte(TextMessage), opcode)
+	assert.Equal(t, "hello", string(message))
+	assert.True(t, &dst[:1][0] == &message[0], "Expected dst to be reused")
+
+	// A message filling dst exactly does not grow it
+	_, message, err = ws.ReceiveInto(dst)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "12345678", string(message))
+	assert.Equal(t, 8, cap(message))
+
+	// A larger message grows dst
+	_, message, err = ws.ReceiveInto(dst)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "fragmented message", string(message))
+
+	_, message, err = ws.ReceiveInto(message)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(message))
+}
+
+// loopConn is a net.Conn replaying the same frames to its reader forever and
+// discarding everything written to it
+type loopConn struct {
+	net.Conn
+	frames []byte
+	offset int
+}
+
+func (c *loopConn) Read(b []byte) (int, error) {
+	n := copy(b, c.frames[c.offset:])
+	c.offset = (c.offset + n) % len(c.frames)
+
+	return n, nil
+}
+
+func (c *loopConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (c *loopConn) Close() error {
+	return nil
+}
+
+func newLoopConn(b *testing.B, frames []byte, request *http.Request) *Conn {
+	c := &loopConn{frames: frames}
+
+	ws, err := NewConn(c, bufio.NewReadWriter(bufio.NewReader(c), bufio.NewWriter(c)), request)
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return ws
+}
+
+func benchmarkSend(b *testing.B, ws *Conn, size int) {
+	payload := bytes.Repeat([]byte("a"), size)
+
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := ws.Send(BinaryMessage, payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSend(b *testing.B) {
+	for _, size := range []int{64, 16 << 10} {
+		name := "64B"
+
+		if size > 64 {
+			name = "16KiB"
+		}
+
+		b.Run("server/"+name, func(b *testing.B) {
+			benchmarkSend(b, newLoopConn(b, []byte{0}, &http.Request{}), size)
+		})
+
+		b.Run("client/"+name, func(b *testing.B) {
+			benchmarkSend(b, newLoopConn(b, []byte{0}, nil), size)
+		})
+
+		b.Run("pool/"+name, func(b *testing.B) {
+			ws := newLoopConn(b, []byte{0}, nil)
+			ws.writePool = &sync.Pool{}
+			ws.brw.Writer = nil
+
+			benchmarkSend(b, ws, size)
+		})
+	}
+}
+
+func benchmarkReceiveInto(b *testing.B, opcode byte, request *http.Request) {
+	payload := bytes.Repeat([]byte("a"), 1024)
+
+	var frames bytes.Buffer
+
+	if request != nil {
+		writeTestFrame(&frames, true, true, opcode, payload)
+	} else {
+		writeTestFrame(&frames, false, true, opcode, payload)
+	}
+
+	ws := newLoopConn(b, frames.Bytes(), request)
+	message := make([]byte, 0, 4096)
+
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var err error
+
+		if _, message, err = ws.ReceiveInto(message); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReceiveInto(b *testing.B) {
+	b.Run("server/binary", func(b *testing.B) {
+		benchmarkReceiveInto(b, BinaryMessage, &http.Request{})
+	})
+
+	b.Run("server/text", func(b *testing.B) {
+		benchmarkReceiveInto(b, TextMessage, &http.Request{})
+	})
+
+	b.Run("client/binary", func(b *testing.B) {
+		benchmarkReceiveInto(b, BinaryMessage, nil)
+	})
+
+	b.Run("client/text", func(b *testing.B) {
+		benchmarkReceiveInto(b, TextMessage, nil)
+	})
+}
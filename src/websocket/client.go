@@ -2,57 +2,373 @@ package websocket
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 )
 
-// Dial open a websocket connection
-func Dial(url string) (Conn, error) {
-	return createClient(url)
+// Dialer contains the options for opening a websocket connection
+type Dialer struct {
+	// Subprotocols lists the application protocols offered to the server in
+	// order of preference
+	Subprotocols []string
+
+	// EnableCompression specifies if the client should offer the
+	// permessage-deflate extension (RFC 7692) to the server
+	EnableCompression bool
+
+	// Extensions lists the extensions offered to the server in order
+	Extensions []Extension
+
+	// TLSClientConfig specifies the TLS configuration used for wss:// URLs,
+	// e.g. custom root CAs, client certificates or the server name. When nil
+	// the default configuration is used.
+	TLSClientConfig *tls.Config
+
+	// HandshakeTimeout is the maximum duration for connecting and completing
+	// the opening handshake, zero means no timeout
+	HandshakeTimeout time.Duration
+
+	// Jar specifies the cookie jar used to add cookies to the handshake
+	// request and to store the cookies set by the server's response
+	Jar http.CookieJar
+
+	// Proxy returns the proxy for a request, http:// proxies are tunnelled
+	// through with CONNECT and socks5:// proxies with SOCKS5. The request's URL
+	// uses the http or https scheme. When Proxy or the returned URL is nil no
+	// proxy is used.
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// WriteBufferPool is a pool of write buffers shared by the connections,
+	// when set a connection only holds a write buffer while writing a frame
+	WriteBufferPool BufferPool
+}
+
+// DefaultDialer is used by Dial, it honours the HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY environment variables
+var DefaultDialer = &Dialer{
+	Proxy: http.ProxyFromEnvironment,
+}
+
+var (
+	errDuplicateHeader = errors.New("websocket: header is set by the dialer")
+
+	// headers managed by the dialer which can not be set by the caller
+	dialerHeaders = []string{
+		"Upgrade",
+		"Connection",
+		"Sec-Websocket-Key",
+		"Sec-Websocket-Version",
+		"Sec-Websocket-Protocol",
+		"Sec-Websocket-Extensions",
+	}
+)
+
+// Dial open a websocket connection, protocols lists the application
+// protocols offered to the server in order of preference
+func Dial(url string, protocols ...string) (*Conn, error) {
+	dialer := *DefaultDialer
+	dialer.Subprotocols = protocols
+
+	return dialer.Dial(url)
+}
+
+// Dial open a websocket connection using the dialer's options
+func (d *Dialer) Dial(url string) (*Conn, error) {
+	conn, _, err := d.DialContext(context.Background(), url, nil)
+
+	return conn, err
+}
+
+// DialContext opens a websocket connection, ctx bounds connecting and the
+// opening handshake. header is added to the handshake request and can be used
+// to set e.g. Origin, Authorization or Cookie. The server's handshake response
+// is returned even when the handshake failed so the status, headers and body
+// can be inspected.
+func (d *Dialer) DialContext(ctx context.Context, url string, header http.Header) (*Conn, *http.Response, error) {
+	return createClient(ctx, url, header, d)
+}
+
+// generateChallengeKey returns a base64 encoded random 16 byte nonce for
+// the Sec-WebSocket-Key header, see rfc6455#section-4.1
+func generateChallengeKey() (string, error) {
+	nonce := make([]byte, 16)
+
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(nonce), nil
+}
+
+// verifyHandshakeResponse checks that the server accepted the upgrade to the
+// websocket protocol, see rfc6455#section-4.1
+func verifyHandshakeResponse(request *http.Request, response *http.Response) error {
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		return ErrBadStatus
+	}
+
+	if !headerContainsToken(response.Header, "Upgrade", "websocket") {
+		return ErrBadUpgrade
+	}
+
+	if !headerContainsToken(response.Header, "Connection", "Upgrade") {
+		return ErrBadUpgrade
+	}
+
+	expected := createWebsocketSecHeader(request.Header.Get("Sec-WebSocket-Key"))
+
+	if response.Header.Get("Sec-WebSocket-Accept") != expected {
+		return ErrChallengeResponse
+	}
+
+	return nil
 }
 
-func createWSSRequest(url string) (*http.Request, error) {
+// httpURL returns the http(s) equivalent of a ws(s) URL, as used for cookies
+func httpURL(u *url.URL) *url.URL {
+	result := *u
+
+	if u.Scheme == "wss" {
+		result.Scheme = "https"
+	} else {
+		result.Scheme = "http"
+	}
+
+	return &result
+}
+
+func createWSSRequest(url string, header http.Header, d *Dialer) (*http.Request, error) {
 	request, err := http.NewRequest("GET", url, nil)
 
 	if err != nil {
 		return request, err
 	}
 
-	request.Header.Set("Origin", "localhost")
+	for name, values := range header {
+		if containsToken(dialerHeaders, http.CanonicalHeaderKey(name)) {
+			return nil, errDuplicateHeader
+		}
+
+		if http.CanonicalHeaderKey(name) == "Host" && len(values) > 0 {
+			request.Host = values[0]
+			continue
+		}
+
+		request.Header[http.CanonicalHeaderKey(name)] = values
+	}
+
+	// Browsers always send an Origin, default to the origin of the URL
+
+	if request.Header.Get("Origin") == "" {
+		request.Header.Set("Origin", httpURL(request.URL).Scheme+"://"+request.URL.Host)
+	}
+
 	request.Header.Set("Upgrade", "websocket")
 	request.Header.Set("Connection", "Upgrade")
-	request.Header.Set("Sec-WebSocket-Key", "AQIDBAUGBwgJCgsMDQ4PEC==")
+	key, err := generateChallengeKey()
+
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Sec-WebSocket-Key", key)
 	request.Header.Set("Sec-WebSocket-Version", "13")
 
+	if len(d.Subprotocols) > 0 {
+		request.Header.Set("Sec-WebSocket-Protocol", strings.Join(d.Subprotocols, ", "))
+	}
+
+	for _, ext := range withCompression(d.EnableCompression, d.Extensions) {
+		request.Header.Add("Sec-WebSocket-Extensions", formatExtension(ext.Name(), ext.Offer()))
+	}
+
+	if d.Jar != nil {
+		for _, cookie := range d.Jar.Cookies(httpURL(request.URL)) {
+			request.AddCookie(cookie)
+		}
+	}
+
 	return request, nil
 }
 
-// RunClient run a ws client
-func createClient(inputURL string) (wsConn Conn, err error) {
+// dialAddress returns the host:port to dial for a ws:// or wss:// URL using
+// the default port of the scheme when none is given
+func dialAddress(u *url.URL) (string, error) {
+	var port string
+
+	switch u.Scheme {
+	case "ws":
+		port = "80"
+	case "wss":
+		port = "443"
+	default:
+		return "", ErrBadScheme
+	}
+
+	if u.Port() != "" {
+		port = u.Port()
+	}
+
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
+
+// tlsHandshake performs the client TLS handshake on conn, the server name
+// defaults to the host of the URL
+func tlsHandshake(ctx context.Context, conn net.Conn, host string, config *tls.Config) (net.Conn, error) {
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+
+	tlsConn := tls.Client(conn, config)
+
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
+
+// maxErrorResponseBody is the number of bytes kept of the body of a failed
+// handshake response
+const maxErrorResponseBody = 1024
+
+// readErrorResponse replaces the body of a failed handshake response with a
+// bounded in memory copy so it can be read after the connection is closed. It
+// must be called while the handshake deadline and the context watcher still
+// apply, so a server stalling in the middle of the body can't block the dial.
+func readErrorResponse(response *http.Response) {
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, maxErrorResponseBody))
+
+	response.Body = ioutil.NopCloser(bytes.NewReader(body))
+}
+
+// createClient connects to the server and performs the opening handshake
+func createClient(ctx context.Context, inputURL string, header http.Header, d *Dialer) (*Conn, *http.Response, error) {
 	parsedURL, err := url.Parse(inputURL)
 
 	if err != nil {
-		return wsConn, err
+		return nil, nil, err
+	}
+
+	address, err := dialAddress(parsedURL)
+
+	if err != nil {
+		return nil, nil, err
 	}
 
-	address := parsedURL.Host
 	fmt.Println("Connecting to", address, "ws server")
 
-	request, err := createWSSRequest(inputURL)
+	request, err := createWSSRequest(inputURL, header, d)
 
 	if err != nil {
 		log.Println("Failed handshake", err)
-		return wsConn, err
+		return nil, nil, err
+	}
+
+	if d.HandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, d.HandshakeTimeout)
+		defer cancel()
+	}
+
+	// Connect to the proxy instead of the server when one is configured
+
+	proxy, err := d.proxyURL(parsedURL)
+
+	if err != nil {
+		return nil, nil, err
 	}
 
-	conn, err := net.Dial("tcp", address)
+	connectAddress := address
+
+	if proxy != nil {
+		if connectAddress, err = proxyAddress(proxy); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	netDialer := &net.Dialer{}
+	netConn, err := netDialer.DialContext(ctx, "tcp", connectAddress)
 
 	if err != nil {
 		log.Println("Failed to open TCP connection to client", err)
-		return wsConn, err
+		return nil, nil, err
+	}
+
+	// Abort blocking reads and writes when the context is done during the
+	// handshake by expiring the connection's deadline. The deadline of the
+	// TCP connection also applies to the TLS connection on top of it.
+
+	if deadline, ok := ctx.Deadline(); ok {
+		netConn.SetDeadline(deadline)
+	}
+
+	// The watcher interrupts the handshake when ctx is done, stopWatcher returns
+	// once it exited so it can't set a deadline on the returned connection
+
+	handshakeDone := make(chan struct{})
+	watcherDone := make(chan struct{})
+
+	stopWatcher := func() {
+		if handshakeDone != nil {
+			close(handshakeDone)
+			<-watcherDone
+			handshakeDone = nil
+		}
+	}
+
+	defer stopWatcher()
+
+	go func(netConn net.Conn) {
+		defer close(watcherDone)
+
+		select {
+		case <-ctx.Done():
+			netConn.SetDeadline(time.Unix(1, 0))
+		case <-handshakeDone:
+		}
+	}(netConn)
+
+	if proxy != nil {
+		if err := proxyConnect(netConn, proxy, address); err != nil {
+			netConn.Close()
+			log.Println("Failed to connect through proxy", proxy.Host, err)
+			return nil, nil, contextError(ctx, err)
+		}
+	}
+
+	conn := netConn
+
+	if parsedURL.Scheme == "wss" {
+		tlsConn, err := tlsHandshake(ctx, netConn, parsedURL.Hostname(), d.TLSClientConfig)
+
+		if err != nil {
+			log.Println("Failed TLS handshake", err)
+			return nil, nil, contextError(ctx, err)
+		}
+
+		conn = tlsConn
 	}
 
 	writer := bufio.NewWriter(conn)
@@ -60,35 +376,110 @@ func createClient(inputURL string) (wsConn Conn, err error) {
 
 	// Write the request handshake
 	request.Write(writer)
-	writer.Flush()
+
+	if err := writer.Flush(); err != nil {
+		conn.Close()
+		return nil, nil, contextError(ctx, err)
+	}
 
 	response, err := http.ReadResponse(reader, request)
 
 	if err != nil {
+		conn.Close()
 		log.Println("Failed to perform handshake", err)
-		return wsConn, err
+		return nil, nil, contextError(ctx, err)
 	}
 
-	if response.StatusCode != 101 {
-		err = fmt.Errorf("Wrong http status code %d", response.StatusCode)
-		log.Println("Failed to perform handshake", err)
-		return wsConn, err
+	if d.Jar != nil {
+		if cookies := response.Cookies(); len(cookies) > 0 {
+			d.Jar.SetCookies(httpURL(request.URL), cookies)
+		}
+	}
+
+	if err := verifyHandshakeResponse(request, response); err != nil {
+		readErrorResponse(response)
+		conn.Close()
+		log.Println("Failed to perform handshake", response.StatusCode, err)
+		return nil, response, err
+	}
+
+	// The body of a successful handshake is the websocket connection itself
+	response.Body = ioutil.NopCloser(bytes.NewReader(nil))
+
+	// The server must select one of the offered protocols or none at all
+
+	subprotocol := response.Header.Get("Sec-WebSocket-Protocol")
+
+	if subprotocol != "" && !containsToken(d.Subprotocols, subprotocol) {
+		conn.Close()
+		log.Println("Failed to perform handshake, server selected protocol", subprotocol)
+		return nil, response, ErrBadWebSocketProtocol
+	}
+
+	// The server may only accept extensions we offered
+
+	extensions, err := configureExtensions(withCompression(d.EnableCompression, d.Extensions), parseExtensions(response.Header))
+
+	if err != nil {
+		conn.Close()
+		log.Println("Failed to perform handshake, bad extensions", response.Header.Get("Sec-WebSocket-Extensions"))
+		return nil, response, err
 	}
 
-	return createWSSConn(conn)
+	stopWatcher()
+
+	if err := ctx.Err(); err != nil {
+		conn.Close()
+		return nil, response, err
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	// Pooled write buffers replace the connection's own buffer
+
+	if d.WriteBufferPool != nil {
+		writer = nil
+	}
+
+	// Frames sent right after the handshake may already be buffered in reader
+	wsConn, err := createWSSConn(conn, bufio.NewReadWriter(reader, writer), subprotocol, extensions)
+
+	if err != nil {
+		conn.Close()
+		return nil, response, err
+	}
+
+	wsConn.writePool = d.WriteBufferPool
+
+	return wsConn, response, nil
 }
 
-func createWSSConn(conn net.Conn) (Conn, error) {
-	r := bufio.NewReader(conn)
-	w := bufio.NewWriter(conn)
-	bufrw := bufio.NewReadWriter(r, w)
+// contextError prefers the context's error when it caused err
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
 
+	// The connection deadline can fire just before the context's timer
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+			return context.DeadlineExceeded
+		}
+	}
+
+	return err
+}
+
+func createWSSConn(conn net.Conn, bufrw *bufio.ReadWriter, subprotocol string, extensions []ExtensionConn) (*Conn, error) {
 	wConn, err := NewConn(conn, bufrw, nil)
 
 	if err != nil {
 		fmt.Println("Failed to create websocket connection in client", err)
-		return wConn, err
+		return nil, err
 	}
 
+	wConn.subprotocol = subprotocol
+	wConn.setExtensions(extensions)
+
 	return wConn, nil
 }
@@ -0,0 +1,485 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newSubprotocolServer(upgrader *Upgrader, responseHeader http.Header, selected chan string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, responseHeader)
+
+		if err != nil {
+			return
+		}
+
+		selected <- conn.Subprotocol()
+		conn.rwc.Close()
+	}))
+}
+
+func TestDialSubprotocol(t *testing.T) {
+	selected := make(chan string, 1)
+	server := newSubprotocolServer(&Upgrader{Subprotocols: []string{"v2.chat", "v1.chat"}}, nil, selected)
+	defer server.Close()
+
+	conn, err := Dial(wsURL(server), "v3.chat", "v1.chat", "v2.chat")
+
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	defer conn.rwc.Close()
+
+	assert.Equal(t, "v1.chat", conn.Subprotocol(), "Expected first offered supported protocol")
+
+	assert.Equal(t, "v1.chat", <-selected, "Expected server to negotiate the same protocol")
+}
+
+func TestDialNoCommonSubprotocol(t *testing.T) {
+	selected := make(chan string, 1)
+	server := newSubprotocolServer(&Upgrader{Subprotocols: []string{"v2.chat"}}, nil, selected)
+	defer server.Close()
+
+	conn, err := Dial(wsURL(server), "v1.chat")
+
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	defer conn.rwc.Close()
+
+	assert.Equal(t, "", conn.Subprotocol())
+	assert.Equal(t, "", <-selected)
+}
+
+func TestDialBadSubprotocol(t *testing.T) {
+	server := newSubprotocolServer(&Upgrader{}, http.Header{"Sec-Websocket-Protocol": {"v2.chat"}}, make(chan string, 1))
+	defer server.Close()
+
+	_, err := Dial(wsURL(server), "v1.chat")
+
+	assert.Equal(t, ErrBadWebSocketProtocol, err)
+}
+
+func TestDialAddress(t *testing.T) {
+	address := func(rawURL string) (string, error) {
+		u, err := url.Parse(rawURL)
+
+		assert.Nil(t, err)
+
+		return dialAddress(u)
+	}
+
+	addr, err := address("ws://example.com/chat")
+	assert.Nil(t, err)
+	assert.Equal(t, "example.com:80", addr)
+
+	addr, err = address("wss://example.com/chat")
+	assert.Nil(t, err)
+	assert.Equal(t, "example.com:443", addr)
+
+	addr, err = address("wss://[::1]:8443/chat")
+	assert.Nil(t, err)
+	assert.Equal(t, "[::1]:8443", addr)
+
+	_, err = address("https://example.com/chat")
+	assert.Equal(t, ErrBadScheme, err)
+}
+
+func TestDialBadScheme(t *testing.T) {
+	_, err := Dial("http://localhost:8080/chat")
+
+	assert.Equal(t, ErrBadScheme, err)
+}
+
+func TestDialTLS(t *testing.T) {
+	upgrader := &Upgrader{}
+	upgraded := make(chan bool, 1)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+
+		upgraded <- err == nil && r.TLS != nil
+
+		if err == nil {
+			conn.rwc.Close()
+		}
+	}))
+	defer server.Close()
+
+	wssURL := strings.Replace(server.URL, "https", "wss", 1)
+
+	// Without the test server's certificate the handshake must fail
+
+	_, err := Dial(wssURL)
+
+	assert.NotNil(t, err, "Expected unknown certificate authority to fail")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+
+	dialer := &Dialer{
+		TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "example.com"},
+	}
+
+	conn, err := dialer.Dial(wssURL)
+
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	defer conn.rwc.Close()
+
+	_, ok := conn.rwc.(*tls.Conn)
+
+	assert.True(t, ok, "Expected connection to use TLS")
+	assert.True(t, <-upgraded, "Expected server to upgrade a TLS request")
+}
+
+func TestDialContextHeaders(t *testing.T) {
+	upgrader := &Upgrader{}
+	requests := make(chan *http.Request, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+
+		conn, err := upgrader.Upgrade(w, r, http.Header{"Set-Cookie": {"session=next"}})
+
+		if err == nil {
+			conn.rwc.Close()
+		}
+	}))
+	defer server.Close()
+
+	jar, _ := cookiejar.New(nil)
+	serverURL, _ := url.Parse(server.URL)
+	jar.SetCookies(serverURL, []*http.Cookie{{Name: "session", Value: "first"}})
+
+	dialer := &Dialer{Jar: jar}
+	header := http.Header{
+		"Authorization": {"Bearer token"},
+		"origin":        {"https://example.com"},
+	}
+
+	conn, response, err := dialer.DialContext(context.Background(), wsURL(server), header)
+
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	defer conn.rwc.Close()
+
+	request := <-requests
+
+	assert.Equal(t, "Bearer token", request.Header.Get("Authorization"))
+	assert.Equal(t, "https://example.com", request.Header.Get("Origin"))
+
+	cookie, err := request.Cookie("session")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "first", cookie.Value)
+
+	assert.Equal(t, http.StatusSwitchingProtocols, response.StatusCode)
+	assert.Equal(t, "session=next", response.Header.Get("Set-Cookie"))
+	assert.Equal(t, "next", jar.Cookies(serverURL)[0].Value, "Expected jar to store the response cookie")
+}
+
+func TestDialContextDefaultOrigin(t *testing.T) {
+	request, err := createWSSRequest("wss://example.com/chat", nil, &Dialer{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "https://example.com", request.Header.Get("Origin"))
+}
+
+func TestDialContextDuplicateHeader(t *testing.T) {
+	_, _, err := (&Dialer{}).DialContext(context.Background(), "ws://localhost/", http.Header{"Upgrade": {"h2c"}})
+
+	assert.Equal(t, errDuplicateHeader, err)
+}
+
+func TestDialContextBadStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Reason", "token expired")
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	conn, response, err := (&Dialer{}).DialContext(context.Background(), wsURL(server), nil)
+
+	assert.Nil(t, conn)
+	assert.Equal(t, ErrBadStatus, err)
+
+	if !assert.NotNil(t, response) {
+		return
+	}
+
+	body, _ := ioutil.ReadAll(response.Body)
+
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+	assert.Equal(t, "token expired", response.Header.Get("X-Reason"))
+	assert.Equal(t, "forbidden\n", string(body))
+}
+
+// silentServer accepts TCP connections but never answers the handshake
+func silentServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			defer conn.Close()
+		}
+	}()
+
+	return listener
+}
+
+func TestDialContextCancel(t *testing.T) {
+	listener := silentServer(t)
+	defer listener.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	_, _, err := (&Dialer{}).DialContext(ctx, "ws://"+listener.Addr().String(), nil)
+
+	assert.Equal(t, context.Canceled, err)
+}
+
+// cancelJar cancels the dial's context when the server's cookies are stored,
+// that is after the handshake response was read
+type cancelJar struct {
+	cancel context.CancelFunc
+}
+
+func (jar cancelJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	jar.cancel()
+}
+
+func (jar cancelJar) Cookies(u *url.URL) []*http.Cookie {
+	return nil
+}
+
+func TestDialContextCancelDuringHandshake(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&Upgrader{}).Upgrade(w, r, http.Header{"Set-Cookie": {"session=1"}})
+
+		if err == nil {
+			conn.rwc.Close()
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// A context canceled before the handshake completes fails the dial
+	// instead of returning a connection with an expired deadline
+	conn, _, err := (&Dialer{Jar: cancelJar{cancel}}).DialContext(ctx, wsURL(server), nil)
+
+	assert.Nil(t, conn)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestDialHandshakeTimeout(t *testing.T) {
+	listener := silentServer(t)
+	defer listener.Close()
+
+	dialer := &Dialer{HandshakeTimeout: 50 * time.Millisecond}
+	start := time.Now()
+
+	_, _, err := dialer.DialContext(context.Background(), "ws://"+listener.Addr().String(), nil)
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < time.Second, "Expected handshake to time out")
+}
+
+func TestDialTLSHandshakeTimeout(t *testing.T) {
+	listener := silentServer(t)
+	defer listener.Close()
+
+	// The server accepts TCP but never answers the TLS handshake
+
+	dialer := &Dialer{HandshakeTimeout: 50 * time.Millisecond}
+	start := time.Now()
+
+	_, _, err := dialer.DialContext(context.Background(), "wss://"+listener.Addr().String(), nil)
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < time.Second, "Expected the TLS handshake to time out")
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	_, _, err = (&Dialer{}).DialContext(ctx, "wss://"+listener.Addr().String(), nil)
+
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestGenerateChallengeKey(t *testing.T) {
+	first, err := generateChallengeKey()
+
+	assert.Nil(t, err)
+
+	second, err := generateChallengeKey()
+
+	assert.Nil(t, err)
+	assert.True(t, first != second, "Expected a fresh nonce per handshake")
+
+	nonce, err := base64.StdEncoding.DecodeString(first)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 16, len(nonce))
+}
+
+func TestCreateWebsocketSecHeader(t *testing.T) {
+	// Example from rfc6455#section-1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", createWebsocketSecHeader("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+// fakeHandshakeServer answers every handshake with response, the
+// placeholder {accept} is replaced with the correct Sec-WebSocket-Accept
+func fakeHandshakeServer(t *testing.T, response string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			request, err := http.ReadRequest(bufio.NewReader(conn))
+
+			if err == nil {
+				accept := createWebsocketSecHeader(request.Header.Get("Sec-WebSocket-Key"))
+				conn.Write([]byte(strings.Replace(response, "{accept}", accept, 1)))
+			}
+
+			conn.Close()
+		}
+	}()
+
+	return listener
+}
+
+// stallingServer answers the handshake with response and then keeps the
+// connection open without sending anything else
+func stallingServer(t *testing.T, response string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			defer conn.Close()
+
+			if _, err := http.ReadRequest(bufio.NewReader(conn)); err == nil {
+				conn.Write([]byte(response))
+			}
+		}
+	}()
+
+	return listener
+}
+
+func TestDialErrorResponseStalls(t *testing.T) {
+	listener := stallingServer(t, "HTTP/1.1 403 Forbidden\r\nContent-Length: 4096\r\n\r\npartial")
+	defer listener.Close()
+
+	// The body of the error response is read under the handshake deadline
+
+	dialer := &Dialer{HandshakeTimeout: 100 * time.Millisecond}
+	start := time.Now()
+
+	_, response, err := dialer.DialContext(context.Background(), "ws://"+listener.Addr().String(), nil)
+
+	assert.Equal(t, ErrBadStatus, err)
+	assert.True(t, time.Since(start) < time.Second, "Expected the stalled body not to block the dial")
+
+	if assert.NotNil(t, response) {
+		body, _ := ioutil.ReadAll(response.Body)
+
+		assert.Equal(t, "partial", string(body))
+	}
+
+	// Or until the context is cancelled
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start = time.Now()
+
+	_, _, err = (&Dialer{}).DialContext(ctx, "ws://"+listener.Addr().String(), nil)
+
+	assert.Equal(t, ErrBadStatus, err)
+	assert.True(t, time.Since(start) < time.Second, "Expected the stalled body not to block the dial")
+}
+
+func TestDialVerifyHandshakeResponse(t *testing.T) {
+	cases := []struct {
+		response string
+		err      error
+	}{
+		{"HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: {accept}\r\n\r\n", nil},
+		{"HTTP/1.1 101 Switching Protocols\r\nUpgrade: WebSocket\r\nConnection: keep-alive, upgrade\r\nSec-WebSocket-Accept: {accept}\r\n\r\n", nil},
+		{"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n", ErrBadStatus},
+		{"HTTP/1.1 101 Switching Protocols\r\nUpgrade: h2c\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: {accept}\r\n\r\n", ErrBadUpgrade},
+		{"HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nSec-WebSocket-Accept: {accept}\r\n\r\n", ErrBadUpgrade},
+		{"HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n", ErrChallengeResponse},
+		{"HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n\r\n", ErrChallengeResponse},
+	}
+
+	for _, c := range cases {
+		listener := fakeHandshakeServer(t, c.response)
+
+		conn, _, err := (&Dialer{}).DialContext(context.Background(), "ws://"+listener.Addr().String(), nil)
+
+		assert.Equal(t, c.err, err, c.response)
+
+		if conn != nil {
+			conn.rwc.Close()
+		}
+
+		listener.Close()
+	}
+}
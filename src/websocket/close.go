@@ -0,0 +1,304 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"time"
+	"unicode/utf8"
+)
+
+// defaultCloseTimeout bounds how long Close waits for the peer's close frame
+const defaultCloseTimeout = 5 * time.Second
+
+// connState is the state of the closing handshake, see rfc6455#section-7
+type connState int
+
+const (
+	// stateOpen is the state until a close frame is sent or received
+	stateOpen connState = iota
+
+	// stateClosing means we sent a close frame and wait for the peer's
+	stateClosing
+
+	// stateClosed means the handshake completed or the connection failed,
+	// the network connection is closed
+	stateClosed
+)
+
+// The readers of a connection, see FrameSpecHandler.startReading
+const (
+	readerNone int32 = iota
+	readerApplication
+	readerClose
+)
+
+var (
+	// ErrCloseSent is returned when sending after a close frame was sent
+	ErrCloseSent = errors.New("websocket: close sent")
+
+	errInvalidCloseCode = errors.New("websocket: invalid close code")
+)
+
+// CloseError is returned by Receive once the peer closed the connection,
+// Code is the peer's status code and Text its reason
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (err *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", err.Code, err.Text)
+}
+
+// IsCloseError reports if err is a *CloseError with one of the codes
+func IsCloseError(err error, codes ...int) bool {
+	if closeErr, ok := err.(*CloseError); ok {
+		for _, code := range codes {
+			if closeErr.Code == code {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// IsUnexpectedCloseError reports if err is a *CloseError with none of the
+// expected codes, e.g. to tell protocol failures from normal disconnects
+func IsUnexpectedCloseError(err error, expectedCodes ...int) bool {
+	if _, ok := err.(*CloseError); ok {
+		return !IsCloseError(err, expectedCodes...)
+	}
+
+	return false
+}
+
+// abnormalClosure reports a connection lost without a close frame or a peer
+// that stopped answering pings as a *CloseError with status 1006
+func (fspec *FrameSpecHandler) abnormalClosure(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return &CloseError{Code: CloseStatusAbnormalClosure, Text: io.ErrUnexpectedEOF.Error()}
+	}
+
+	if isKeepAliveTimeout(fspec.conn, err) && fspec.getState() == stateOpen {
+		closeErr := &CloseError{Code: CloseStatusAbnormalClosure, Text: "keepalive timeout"}
+		fspec.setClosed(closeErr)
+
+		return closeErr
+	}
+
+	return err
+}
+
+// isValidCloseCode reports if code may be sent in a close frame, 1005, 1006
+// and 1015 are reserved for reporting, see rfc6455#section-7.4
+func isValidCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003:
+		return true
+	case code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+
+	return false
+}
+
+// FormatCloseMessage returns the payload of a close frame with a status code
+// and reason, CloseStatusNoStatusRcvd is formatted as an empty payload
+func FormatCloseMessage(code int, text string) []byte {
+	if code == CloseStatusNoStatusRcvd {
+		return nil
+	}
+
+	payload := make([]byte, 2, 2+len(text))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+
+	return append(payload, text...)
+}
+
+// parseClosePayload returns the status code and reason of a close frame
+func parseClosePayload(payload []byte) (*CloseError, error) {
+	if len(payload) == 0 {
+		return &CloseError{Code: CloseStatusNoStatusRcvd}, nil
+	}
+
+	if len(payload) == 1 {
+		return nil, ErrBadClosePayload
+	}
+
+	closeErr := &CloseError{
+		Code: int(binary.BigEndian.Uint16(payload)),
+		Text: string(payload[2:]),
+	}
+
+	if !isValidCloseCode(closeErr.Code) {
+		return nil, ErrBadCloseCode
+	}
+
+	// The close reason is UTF-8 text
+	if !utf8.ValidString(closeErr.Text) {
+		return nil, ErrInvalidUTF8
+	}
+
+	return closeErr, nil
+}
+
+// getState returns the state of the closing handshake
+func (fspec *FrameSpecHandler) getState() connState {
+	fspec.mu.Lock()
+	defer fspec.mu.Unlock()
+
+	return fspec.state
+}
+
+// writeClose sends a close frame while the connection is open and moves it to
+// the closing state
+func (fspec *FrameSpecHandler) writeClose(payload []byte) error {
+	if len(payload) > maxControlFramePayloadLength {
+		return errControlFrameTooLarge
+	}
+
+	fspec.mu.Lock()
+
+	if fspec.state != stateOpen {
+		fspec.mu.Unlock()
+		return ErrCloseSent
+	}
+
+	fspec.state = stateClosing
+	fspec.mu.Unlock()
+
+	return fspec.writeControl(CloseMessage, payload)
+}
+
+// setClosed ends the closing handshake, closeErr is returned by the following
+// reads and the network connection is closed
+func (fspec *FrameSpecHandler) setClosed(closeErr *CloseError) {
+	fspec.mu.Lock()
+
+	if fspec.state != stateClosed {
+		fspec.state = stateClosed
+		fspec.closeErr = closeErr
+		close(fspec.closed)
+	}
+
+	fspec.mu.Unlock()
+
+	fspec.conn.rwc.Close()
+}
+
+func (fspec *FrameSpecHandler) handleCloseMessage(fh FrameHeader, reader io.Reader) error {
+	message, err := fspec.readControl(fh, reader)
+
+	if err != nil {
+		return err
+	}
+
+	closeErr, err := parseClosePayload(message)
+
+	switch err {
+	case nil:
+	case ErrInvalidUTF8:
+		return fspec.failConnection(CloseStatusBadMessageData, "invalid UTF-8", err)
+	default:
+		return fspec.failConnection(CloseStatusProtocolError, "bad close frame", err)
+	}
+
+	log.Println("Received CLOSE opcode with status:", closeErr.Code, closeErr.Text)
+
+	handlerErr := fspec.conn.closeHandler(closeErr.Code, closeErr.Text)
+
+	// Echo the peer's status code unless a close frame was already sent
+	if fspec.getState() == stateOpen {
+		fspec.writeClose(FormatCloseMessage(closeErr.Code, ""))
+	}
+
+	fspec.setClosed(closeErr)
+
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	return closeErr
+}
+
+// CloseConnection performs the closing handshake, it sends a close frame and
+// waits for the peer's close frame up to the close timeout before closing the
+// network connection
+func (fspec *FrameSpecHandler) CloseConnection(statusCode int, statusMessage string) error {
+	if !isValidCloseCode(statusCode) {
+		return errInvalidCloseCode
+	}
+
+	if err := fspec.writeClose(FormatCloseMessage(statusCode, statusMessage)); err != nil {
+		if err == ErrCloseSent {
+			return nil
+		}
+
+		fspec.setClosed(nil)
+
+		return err
+	}
+
+	fspec.waitClose()
+
+	return nil
+}
+
+// waitClose waits for the peer's close frame until the close timeout expires.
+// The frames are read and discarded here when the application never read the
+// connection, otherwise the application's reader receives the close frame.
+func (fspec *FrameSpecHandler) waitClose() {
+	conn := fspec.conn
+	timeout := conn.closeTimeout
+
+	if timeout <= 0 {
+		timeout = defaultCloseTimeout
+	}
+
+	// The deadline also unblocks a concurrent reader of a silent peer
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	if fspec.startReading(readerClose) {
+		fspec.discardUntilClose()
+	} else {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		select {
+		case <-fspec.closed:
+		case <-timer.C:
+		}
+	}
+
+	fspec.setClosed(nil)
+}
+
+// discardUntilClose reads and discards frames until the peer's close frame is
+// handled or reading fails
+func (fspec *FrameSpecHandler) discardUntilClose() {
+	for fspec.getState() != stateClosed {
+		if _, err := fspec.nextFrame(); err != nil {
+			return
+		}
+
+		if _, err := io.Copy(ioutil.Discard, &fspec.payload); err != nil {
+			return
+		}
+	}
+}
+
+// failConnection sends a close frame with statusCode, unless one was already
+// sent, closes the underlying connection and returns err
+func (fspec *FrameSpecHandler) failConnection(statusCode int, reason string, err error) error {
+	fspec.writeClose(FormatCloseMessage(statusCode, reason))
+	fspec.setClosed(nil)
+
+	return err
+}
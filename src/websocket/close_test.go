@@ -0,0 +1,300 @@
+package websocket
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCloseHandshake(t *testing.T) {
+	src, ws, err := wsPipe(nil)
+
+	assert.Nil(t, err)
+
+	// The peer echoes the close frame
+	go func() {
+		frames, err := readRawFrames(src)
+
+		if assert.Nil(t, err) {
+			writeTestFrame(src, false, true, CloseMessage, frames[0].payload)
+		}
+	}()
+
+	// The reading goroutine receives the peer's close frame
+	received := make(chan error, 1)
+
+	go func() {
+		_, _, err := ws.Receive()
+		received <- err
+	}()
+
+	start := time.Now()
+
+	assert.Nil(t, ws.Close())
+	assert.True(t, time.Since(start) < time.Second, "Expected Close to return on the peer's close frame")
+	assert.Equal(t, &CloseError{Code: CloseStatusNormal}, <-received)
+	assert.Equal(t, ErrCloseSent, ws.Send(TextMessage, []byte("late")))
+
+	_, err = ws.NextWriter(TextMessage)
+
+	assert.Equal(t, ErrCloseSent, err)
+
+	_, _, err = ws.Receive()
+
+	assert.Equal(t, &CloseError{Code: CloseStatusNormal}, err)
+}
+
+func TestCloseWithoutReader(t *testing.T) {
+	src, ws, err := wsPipe(nil)
+
+	assert.Nil(t, err)
+
+	// The peer sends a message and a ping before echoing the close frame
+	go func() {
+		frames, err := readRawFrames(src)
+
+		if assert.Nil(t, err) {
+			writeTestFrame(src, false, false, TextMessage, []byte("unread "))
+			writeTestFrame(src, false, true, ContinuationFrame, []byte("message"))
+			writeTestFrame(src, false, true, PingMessage, nil)
+			writeTestFrame(src, false, true, CloseMessage, frames[0].payload)
+		}
+
+		io.Copy(ioutil.Discard, src)
+	}()
+
+	// Close reads the peer's close frame as nothing else reads the connection
+	start := time.Now()
+
+	assert.Nil(t, ws.Close())
+	assert.True(t, time.Since(start) < time.Second, "Expected Close to return on the peer's close frame")
+
+	_, _, err = ws.Receive()
+
+	assert.Equal(t, &CloseError{Code: CloseStatusNormal}, err)
+}
+
+func TestCloseEchoesStatusCode(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	go writeTestFrame(src, true, true, CloseMessage, FormatCloseMessage(CloseStatusGoingAway, "bye"))
+
+	status := make(chan int, 1)
+
+	go func() {
+		status <- closeStatus(t, src)
+	}()
+
+	_, _, err = ws.Receive()
+
+	assert.Equal(t, &CloseError{Code: CloseStatusGoingAway, Text: "bye"}, err)
+	assert.Equal(t, CloseStatusGoingAway, <-status)
+
+	// The peer's close is reported by every following read
+	_, _, err = ws.Receive()
+
+	assert.Equal(t, &CloseError{Code: CloseStatusGoingAway, Text: "bye"}, err)
+	assert.Equal(t, ErrCloseSent, ws.Send(TextMessage, []byte("late")))
+	assert.Nil(t, ws.Close())
+}
+
+func TestCloseEmptyPayload(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	go writeTestFrame(src, true, true, CloseMessage, nil)
+
+	echo := make(chan []rawFrame, 1)
+
+	go func() {
+		frames, _ := readRawFrames(src)
+		echo <- frames
+	}()
+
+	_, _, err = ws.Receive()
+
+	assert.Equal(t, &CloseError{Code: CloseStatusNoStatusRcvd}, err)
+
+	// 1005 is never sent, the echo has no payload
+	if frames := <-echo; assert.Equal(t, 1, len(frames)) {
+		assert.Equal(t, byte(CloseMessage), frames[0].header.Opcode())
+		assert.Equal(t, 0, len(frames[0].payload))
+	}
+}
+
+func TestCloseBadPayload(t *testing.T) {
+	cases := map[string][]byte{
+		"one byte":      {0x03},
+		"no status":     FormatCloseMessage(CloseStatusAbnormalClosure, ""),
+		"below 1000":    {0x03, 0xe7},
+		"reserved 1004": FormatCloseMessage(CloseStatusFrameTooLarge, ""),
+		"tls 1015":      FormatCloseMessage(CloseStatusTLSHandshake, ""),
+		"unassigned":    FormatCloseMessage(2000, ""),
+		"too large":     FormatCloseMessage(5000, ""),
+	}
+
+	for name, payload := range cases {
+		src, ws, err := wsPipe(&http.Request{})
+
+		assert.Nil(t, err)
+
+		go writeTestFrame(src, true, true, CloseMessage, payload)
+
+		status := make(chan int, 1)
+
+		go func() {
+			status <- closeStatus(t, src)
+		}()
+
+		_, _, err = ws.Receive()
+
+		_, ok := err.(*ProtocolError)
+
+		assert.True(t, ok, "Expected a protocol error", name, err)
+		assert.Equal(t, CloseStatusProtocolError, <-status, name)
+	}
+}
+
+func TestCloseTimeout(t *testing.T) {
+	src, ws, err := wsPipe(nil)
+
+	assert.Nil(t, err)
+
+	ws.SetCloseTimeout(50 * time.Millisecond)
+
+	// The peer reads the close frame but never replies
+	go io.Copy(ioutil.Discard, src)
+
+	start := time.Now()
+
+	assert.Nil(t, ws.Close())
+	assert.True(t, time.Since(start) < time.Second, "Expected Close to stop waiting after the timeout")
+}
+
+func TestCloseConcurrentReader(t *testing.T) {
+	src, ws, err := wsPipe(nil)
+
+	assert.Nil(t, err)
+
+	received := make(chan error, 1)
+
+	go func() {
+		_, _, err := ws.Receive()
+		received <- err
+	}()
+
+	go func() {
+		frames, err := readRawFrames(src)
+
+		if assert.Nil(t, err) {
+			writeTestFrame(src, false, true, CloseMessage, frames[0].payload)
+		}
+	}()
+
+	assert.Nil(t, ws.Close())
+	assert.Equal(t, &CloseError{Code: CloseStatusNormal}, <-received)
+}
+
+func TestCloseDuringNextWriter(t *testing.T) {
+	src, ws, err := wsPipe(nil)
+
+	assert.Nil(t, err)
+	assert.Nil(t, ws.SetWriteFrameSize(3))
+
+	ws.SetCloseTimeout(50 * time.Millisecond)
+
+	frames := make(chan rawFrame, 10)
+
+	go func() {
+		defer close(frames)
+
+		for {
+			frame, err := readRawFrame(src)
+
+			if err != nil {
+				return
+			}
+
+			frames <- frame
+		}
+	}()
+
+	w, err := ws.NextWriter(TextMessage)
+
+	assert.Nil(t, err)
+
+	// The first frame is sent before Close
+	_, err = w.Write([]byte("abcd"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, byte(TextMessage), (<-frames).header.Opcode())
+
+	closed := make(chan error, 1)
+
+	go func() {
+		closed <- ws.Close()
+	}()
+
+	assert.Equal(t, byte(CloseMessage), (<-frames).header.Opcode())
+
+	// The rest of the message must not follow the close frame
+	_, err = w.Write([]byte("efgh"))
+
+	assert.Equal(t, ErrCloseSent, err)
+	assert.Equal(t, ErrCloseSent, w.Close())
+	assert.Nil(t, <-closed)
+
+	for frame := range frames {
+		t.Error("Expected no frame after the close frame", frame.header.Opcode())
+	}
+}
+
+func TestCloseInvalidCode(t *testing.T) {
+	_, ws, err := wsPipe(nil)
+
+	assert.Nil(t, err)
+
+	for _, code := range []int{0, CloseStatusNoStatusRcvd, CloseStatusAbnormalClosure, CloseStatusTLSHandshake, 2999, 5000} {
+		assert.Equal(t, errInvalidCloseCode, ws.Handler.CloseConnection(code, ""), code)
+	}
+}
+
+func TestIsCloseError(t *testing.T) {
+	normal := &CloseError{Code: CloseStatusNormal}
+	protocol := &CloseError{Code: CloseStatusProtocolError, Text: "bad frame"}
+
+	assert.True(t, IsCloseError(normal, CloseStatusNormal))
+	assert.True(t, IsCloseError(protocol, CloseStatusNormal, CloseStatusProtocolError))
+	assert.False(t, IsCloseError(protocol, CloseStatusNormal))
+	assert.False(t, IsCloseError(io.EOF, CloseStatusNormal))
+	assert.False(t, IsCloseError(nil, CloseStatusNormal))
+
+	assert.False(t, IsUnexpectedCloseError(normal, CloseStatusNormal, CloseStatusGoingAway))
+	assert.True(t, IsUnexpectedCloseError(protocol, CloseStatusNormal, CloseStatusGoingAway))
+	assert.False(t, IsUnexpectedCloseError(io.EOF, CloseStatusNormal))
+	assert.False(t, IsUnexpectedCloseError(nil))
+}
+
+func TestAbnormalClosure(t *testing.T) {
+	src, ws, err := wsPipe(nil)
+
+	assert.Nil(t, err)
+
+	// The peer goes away in the middle of a frame without a close frame
+	go func() {
+		src.Write([]byte{0x81, 0x05, 'h', 'e'})
+		src.Close()
+	}()
+
+	_, _, err = ws.Receive()
+
+	assert.True(t, IsCloseError(err, CloseStatusAbnormalClosure), err)
+	assert.True(t, IsUnexpectedCloseError(err, CloseStatusNormal))
+}
@@ -0,0 +1,316 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// permessage-deflate compression extension, see RFC 7692
+const (
+	deflateExtensionName = "permessage-deflate"
+
+	serverNoContextTakeover = "server_no_context_takeover"
+	clientNoContextTakeover = "client_no_context_takeover"
+	serverMaxWindowBits     = "server_max_window_bits"
+	clientMaxWindowBits     = "client_max_window_bits"
+
+	// compress/flate always uses a 32KB (2^15) LZ77 window
+	maxWindowBits = 15
+	maxWindowSize = 1 << maxWindowBits
+
+	minCompressionLevel     = flate.HuffmanOnly
+	maxCompressionLevel     = flate.BestCompression
+	defaultCompressionLevel = 1
+)
+
+var (
+	errInvalidCompressionLevel = errors.New("websocket: invalid compression level")
+
+	// deflateTail is the empty stored block each compressed message ends with,
+	// it is removed before sending and appended again before inflating
+	deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+	// deflateFinal is the tail followed by a final empty stored block so
+	// the flate reader returns io.EOF at the end of each message
+	deflateFinal = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+)
+
+// deflateParams are the negotiated permessage-deflate extension parameters
+type deflateParams struct {
+	serverNoContextTakeover bool
+	clientNoContextTakeover bool
+}
+
+// compression holds the per connection permessage-deflate state
+type compression struct {
+	level        int
+	writeEnabled bool
+
+	writeNoContextTakeover bool
+	readNoContextTakeover  bool
+
+	// Writing
+	fw    *flate.Writer
+	trunc truncWriter
+
+	// Reading
+	fr     io.ReadCloser
+	window []byte
+}
+
+func newCompression(isServer bool, params deflateParams) *compression {
+	c := &compression{
+		level:        defaultCompressionLevel,
+		writeEnabled: true,
+	}
+
+	if isServer {
+		c.writeNoContextTakeover = params.serverNoContextTakeover
+		c.readNoContextTakeover = params.clientNoContextTakeover
+	} else {
+		c.writeNoContextTakeover = params.clientNoContextTakeover
+		c.readNoContextTakeover = params.serverNoContextTakeover
+	}
+
+	return c
+}
+
+// deflateExtension negotiates permessage-deflate, it is used when
+// compression is enabled on the Upgrader or Dialer
+type deflateExtension struct{}
+
+func (deflateExtension) Name() string {
+	return deflateExtensionName
+}
+
+// Offer never includes client_max_window_bits since we can't compress with
+// a smaller window
+func (deflateExtension) Offer() ExtensionParams {
+	return ExtensionParams{}
+}
+
+func (deflateExtension) Accept(offer ExtensionParams) (ExtensionParams, ExtensionConn, bool) {
+	response := ExtensionParams{}
+	params := deflateParams{}
+
+	for name, value := range offer {
+		switch name {
+		case serverNoContextTakeover:
+			params.serverNoContextTakeover = true
+			response[name] = ""
+		case clientNoContextTakeover:
+			params.clientNoContextTakeover = true
+			response[name] = ""
+		case serverMaxWindowBits:
+			// We can not compress with a window smaller than the flate window
+			if bits, err := strconv.Atoi(value); err != nil || bits != maxWindowBits {
+				return nil, nil, false
+			}
+		case clientMaxWindowBits:
+			// Our inflater supports any window size, no need to limit the client
+			if bits, err := strconv.Atoi(value); value != "" && (err != nil || bits < 8 || bits > maxWindowBits) {
+				return nil, nil, false
+			}
+		default:
+			return nil, nil, false
+		}
+	}
+
+	return response, newCompression(true, params), true
+}
+
+func (deflateExtension) Configure(response ExtensionParams) (ExtensionConn, error) {
+	params := deflateParams{}
+
+	for name, value := range response {
+		switch name {
+		case serverNoContextTakeover:
+			params.serverNoContextTakeover = true
+		case clientNoContextTakeover:
+			params.clientNoContextTakeover = true
+		case serverMaxWindowBits:
+			if bits, err := strconv.Atoi(value); err != nil || bits < 8 || bits > maxWindowBits {
+				return nil, ErrBadWebSocketExtension
+			}
+		default:
+			return nil, ErrBadWebSocketExtension
+		}
+	}
+
+	return newCompression(false, params), nil
+}
+
+// withCompression prepends the deflate extension to extensions when enabled
+func withCompression(enabled bool, extensions []Extension) []Extension {
+	if !enabled {
+		return extensions
+	}
+
+	return append([]Extension{deflateExtension{}}, extensions...)
+}
+
+func (c *compression) setLevel(level int) error {
+	if level < minCompressionLevel || level > maxCompressionLevel {
+		return errInvalidCompressionLevel
+	}
+
+	if level != c.level {
+		c.level = level
+		c.fw = nil
+	}
+
+	return nil
+}
+
+// RSV claims RSV1 which marks compressed messages
+func (c *compression) RSV() byte {
+	return RSV1
+}
+
+// NewWriter compresses the message written to w when write compression is
+// enabled, the tail of the final flush is removed on Close
+func (c *compression) NewWriter(header *FrameHeader, w io.WriteCloser) (io.WriteCloser, error) {
+	if !c.writeEnabled {
+		return w, nil
+	}
+
+	header.rsv1 = true
+
+	c.trunc = truncWriter{w: w}
+
+	if c.fw == nil {
+		fw, err := flate.NewWriter(&c.trunc, c.level)
+
+		if err != nil {
+			return nil, err
+		}
+
+		c.fw = fw
+	} else if c.writeNoContextTakeover {
+		c.fw.Reset(&c.trunc)
+	}
+
+	return &deflateWriter{c, w}, nil
+}
+
+// NewReader inflates the message read from r when RSV1 is set
+func (c *compression) NewReader(header FrameHeader, r io.Reader) (io.Reader, error) {
+	if !header.rsv1 {
+		return r, nil
+	}
+
+	src := io.MultiReader(r, bytes.NewReader(deflateFinal))
+
+	var dict []byte
+
+	if !c.readNoContextTakeover {
+		dict = c.window
+	}
+
+	if c.fr == nil {
+		c.fr = flate.NewReaderDict(src, dict)
+	} else {
+		c.fr.(flate.Resetter).Reset(src, dict)
+	}
+
+	return &inflateReader{c}, nil
+}
+
+// deflateWriter writes a single message through the flate writer
+type deflateWriter struct {
+	c *compression
+	w io.WriteCloser
+}
+
+func (w *deflateWriter) Write(b []byte) (int, error) {
+	return w.c.fw.Write(b)
+}
+
+// Close flushes the compressed message, the flush ends with the tail which
+// truncWriter keeps from the underlying writer. The underlying writer is
+// always closed so the next message can be written.
+func (w *deflateWriter) Close() error {
+	err := w.c.fw.Flush()
+
+	if err == nil && !bytes.Equal(w.c.trunc.p[:w.c.trunc.n], deflateTail) {
+		err = errors.New("websocket: flate flush did not end with the expected tail")
+	}
+
+	if closeErr := w.w.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// truncWriter writes everything except the last four bytes to w
+type truncWriter struct {
+	w io.Writer
+	n int
+	p [4]byte
+}
+
+func (w *truncWriter) Write(p []byte) (int, error) {
+	n := 0
+
+	// Fill the held back bytes first
+
+	if w.n < len(w.p) {
+		n = copy(w.p[w.n:], p)
+		p = p[n:]
+		w.n += n
+
+		if len(p) == 0 {
+			return n, nil
+		}
+	}
+
+	// Write the oldest held back bytes and keep the last bytes of p
+
+	m := len(p)
+
+	if m > len(w.p) {
+		m = len(w.p)
+	}
+
+	if nn, err := w.w.Write(w.p[:m]); err != nil {
+		return n + nn, err
+	}
+
+	copy(w.p[:], w.p[m:])
+	copy(w.p[len(w.p)-m:], p[len(p)-m:])
+
+	nn, err := w.w.Write(p[:len(p)-m])
+
+	return n + nn, err
+}
+
+// inflateReader reads from the flate reader and keeps track of the LZ77
+// window when the peer uses context takeover
+type inflateReader struct {
+	c *compression
+}
+
+func (r *inflateReader) Read(b []byte) (n int, err error) {
+	n, err = r.c.fr.Read(b)
+
+	if !r.c.readNoContextTakeover {
+		r.c.window = appendWindow(r.c.window, b[:n])
+	}
+
+	return n, err
+}
+
+func appendWindow(window []byte, p []byte) []byte {
+	window = append(window, p...)
+
+	if len(window) > maxWindowSize {
+		n := copy(window, window[len(window)-maxWindowSize:])
+		window = window[:n]
+	}
+
+	return window
+}
@@ -0,0 +1,323 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func compressionPair(params deflateParams) (server *compression, client *compression) {
+	return newCompression(true, params), newCompression(false, params)
+}
+
+func compress(c *compression, msg []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	fh := FrameHeader{opcode: TextMessage}
+
+	w, err := c.NewWriter(&fh, nopWriteCloser{buf})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = w.Write(msg); err != nil {
+		return nil, err
+	}
+
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decompress(c *compression, compressed []byte) ([]byte, error) {
+	fh := FrameHeader{opcode: TextMessage, rsv1: true}
+
+	r, err := c.NewReader(fh, bytes.NewReader(compressed))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(r)
+}
+
+func roundTrip(t *testing.T, from *compression, to *compression, msg string) int {
+	compressed, err := compress(from, []byte(msg))
+
+	assert.Nil(t, err)
+	assert.False(t, bytes.HasSuffix(compressed, deflateTail), "Expected tail to be stripped")
+
+	result, err := decompress(to, compressed)
+
+	assert.Nil(t, err)
+	assert.Equal(t, msg, string(result))
+
+	return len(compressed)
+}
+
+func TestCompressionContextTakeover(t *testing.T) {
+	server, client := compressionPair(deflateParams{})
+
+	msg := strings.Repeat(`{"event":"message","payload":"hello"}`, 20)
+
+	first := roundTrip(t, client, server, msg)
+
+	roundTrip(t, server, client, msg)
+	roundTrip(t, server, client, "")
+
+	// With context takeover the second message can refer to the first one
+	second := roundTrip(t, client, server, msg)
+
+	assert.True(t, second < first, "Expected smaller message using the previous context")
+}
+
+func TestCompressionNoContextTakeover(t *testing.T) {
+	server, client := compressionPair(deflateParams{serverNoContextTakeover: true, clientNoContextTakeover: true})
+
+	msg := strings.Repeat("no context takeover ", 50)
+
+	for i := 0; i < 3; i++ {
+		roundTrip(t, client, server, msg)
+		roundTrip(t, server, client, msg)
+	}
+
+	// Without context takeover each message can be inflated on its own
+	compressed, _ := compress(client, []byte(msg))
+
+	result, err := decompress(newCompression(true, deflateParams{}), compressed)
+
+	assert.Nil(t, err)
+	assert.Equal(t, msg, string(result))
+	assert.Equal(t, 0, len(server.window), "Expected no window to be kept")
+}
+
+func TestCompressionLevel(t *testing.T) {
+	c := newCompression(true, deflateParams{})
+
+	assert.Nil(t, c.setLevel(flate.BestSpeed))
+	assert.Nil(t, c.setLevel(flate.HuffmanOnly))
+	assert.Equal(t, errInvalidCompressionLevel, c.setLevel(flate.BestCompression+1))
+}
+
+func TestDeflateExtensionAccept(t *testing.T) {
+	header := http.Header{}
+	header.Add("Sec-WebSocket-Extensions", "x-unknown, permessage-deflate; server_max_window_bits=10")
+	header.Add("Sec-WebSocket-Extensions", "permessage-deflate; client_max_window_bits; server_no_context_takeover")
+
+	response, conns := acceptExtensions(withCompression(true, nil), parseExtensions(header))
+
+	assert.Equal(t, []string{"permessage-deflate; server_no_context_takeover"}, response)
+	assert.Equal(t, 1, len(conns))
+	assert.True(t, conns[0].(*compression).writeNoContextTakeover)
+
+	header.Set("Sec-WebSocket-Extensions", "permessage-deflate; unknown_param")
+
+	response, _ = acceptExtensions(withCompression(true, nil), parseExtensions(header))
+
+	assert.Equal(t, 0, len(response), "Expected offer with unknown parameter to be declined")
+}
+
+func TestDeflateExtensionConfigure(t *testing.T) {
+	configure := func(value string) ([]ExtensionConn, error) {
+		return configureExtensions(withCompression(true, nil), parseExtensions(http.Header{"Sec-Websocket-Extensions": {value}}))
+	}
+
+	conns, err := configure("permessage-deflate; client_no_context_takeover; server_max_window_bits=12")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(conns))
+	assert.True(t, conns[0].(*compression).writeNoContextTakeover)
+
+	_, err = configure("permessage-deflate; client_max_window_bits=10")
+	assert.Equal(t, ErrBadWebSocketExtension, err, "Expected parameter we did not offer to fail")
+
+	_, err = configure("x-unknown")
+	assert.Equal(t, ErrBadWebSocketExtension, err, "Expected extension we did not offer to fail")
+
+	conns, err = configureExtensions(withCompression(true, nil), nil)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(conns))
+}
+
+// compressedPipe returns a server side connection with permessage-deflate
+func compressedPipe() (src net.Conn, ws *Conn, err error) {
+	src, ws, err = wsPipe(&http.Request{})
+
+	if err == nil {
+		ws.setExtensions([]ExtensionConn{newCompression(true, deflateParams{})})
+	}
+
+	return src, ws, err
+}
+
+func TestWSReadCompressedMessage(t *testing.T) {
+	src, ws, err := compressedPipe()
+
+	assert.Nil(t, err)
+
+	msg := strings.Repeat("compressed ", 100)
+	payload, _ := compress(newCompression(false, deflateParams{}), []byte(msg))
+
+	fh := NewFrameHeader(true, TextMessage, true, [4]byte{0x1, 0x2, 0x3, 0x4}, int64(len(payload)))
+	fh.rsv1 = true
+
+	go func() {
+		src.Write(AppendFrameHeader(nil, fh))
+		NewMaskedWriter(src, fh.maskBytes).Write(payload)
+	}()
+
+	opcode, reader, err := ws.Handler.NextReader()
+
+	assert.Nil(t, err)
+	assert.Equal(t, byte(TextMessage), opcode)
+
+	result, err := ioutil.ReadAll(reader)
+
+	assert.Nil(t, err)
+	assert.Equal(t, msg, string(result))
+}
+
+func TestWSWriteCompressedMessage(t *testing.T) {
+	src, ws, err := compressedPipe()
+
+	assert.Nil(t, err)
+
+	msg := []byte(strings.Repeat("compressed ", 100))
+
+	sent := make(chan error, 1)
+
+	go func() {
+		sent <- ws.Send(BinaryMessage, msg)
+	}()
+
+	header := make([]byte, 2)
+	_, err = io.ReadFull(src, header)
+
+	assert.Nil(t, err)
+	assert.True(t, header[0]&rsv1BitMask != 0, "Expected RSV1 to be set")
+	assert.True(t, header[1]&payloadLengthMask < byte(len(msg)), "Expected compressed payload")
+
+	payload := make([]byte, header[1]&payloadLengthMask)
+	_, err = io.ReadFull(src, payload)
+
+	assert.Nil(t, err)
+
+	result, err := decompress(newCompression(false, deflateParams{}), payload)
+
+	assert.Nil(t, err)
+	assert.Equal(t, msg, result)
+	assert.Nil(t, <-sent)
+
+	ws.EnableWriteCompression(false)
+
+	go ws.Send(BinaryMessage, []byte("plain"))
+
+	_, err = io.ReadFull(src, header)
+
+	assert.Nil(t, err)
+	assert.True(t, header[0]&rsv1BitMask == 0, "Expected RSV1 not to be set")
+}
+
+func TestWSRejectUnnegotiatedRSV1(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	fh := NewFrameHeader(true, TextMessage, true, [4]byte{0x1, 0x2, 0x3, 0x4}, 0)
+	fh.rsv1 = true
+
+	go src.Write(AppendFrameHeader(nil, fh))
+
+	status := make(chan int, 1)
+
+	go func() {
+		status <- closeStatus(t, src)
+	}()
+
+	_, _, err = ws.Handler.NextReader()
+
+	assert.Equal(t, ErrBadRSV, err)
+	assert.Equal(t, CloseStatusProtocolError, <-status)
+}
+
+func TestWSRejectContinuationRSV1(t *testing.T) {
+	src, ws, err := compressedPipe()
+
+	assert.Nil(t, err)
+
+	// Only the first frame of a compressed message may set RSV1
+
+	go func() {
+		first := NewFrameHeader(false, TextMessage, true, testMaskKey, 0)
+		first.rsv1 = true
+
+		next := NewFrameHeader(true, ContinuationFrame, true, testMaskKey, 0)
+		next.rsv1 = true
+
+		src.Write(AppendFrameHeader(nil, first))
+		src.Write(AppendFrameHeader(nil, next))
+	}()
+
+	status := make(chan int, 1)
+
+	go func() {
+		status <- closeStatus(t, src)
+	}()
+
+	_, _, err = ws.Receive()
+
+	assert.Equal(t, ErrContinuationRSV1, err)
+	assert.Equal(t, CloseStatusProtocolError, <-status)
+}
+
+func TestDialCompression(t *testing.T) {
+	upgrader := &Upgrader{EnableCompression: true}
+	negotiated := make(chan bool, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+
+		if err != nil {
+			negotiated <- false
+			return
+		}
+
+		negotiated <- conn.compression != nil
+		conn.rwc.Close()
+	}))
+	defer server.Close()
+
+	dialer := &Dialer{EnableCompression: true}
+	conn, err := dialer.Dial(wsURL(server))
+
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	defer conn.rwc.Close()
+
+	assert.True(t, <-negotiated, "Expected server to negotiate compression")
+	assert.NotNil(t, conn.compression, "Expected client to negotiate compression")
+
+	conn, err = Dial(wsURL(server))
+
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	defer conn.rwc.Close()
+
+	assert.False(t, <-negotiated, "Expected no compression without an offer")
+	assert.Nil(t, conn.compression)
+}
@@ -3,11 +3,10 @@ package websocket
 import (
 	"bufio"
 	"errors"
-	"fmt"
 	"io"
-	"math/rand"
 	"net"
 	"net/http"
+	"sync/atomic"
 	"time"
 )
 
@@ -16,19 +15,48 @@ var (
 )
 
 // Conn struct to resemble a websocket connection
+//
+// A Conn supports one concurrent reader and any number of concurrent writers:
+// Receive, Read and NextReader must be called from a single goroutine while
+// Send, Write, NextWriter and Close may be called from any goroutine. Every
+// message is sent atomically, control frames such as the pong replying to a
+// ping can be sent between the frames of a message written with NextWriter.
 type Conn struct {
 	rwc       io.ReadWriteCloser
 	Handler   FrameHandler
 	FrameType byte
 	request   *http.Request
+	params    map[string]string
+
+	subprotocol string
+	extensions  []ExtensionConn
+	rsv         byte
+	compression *compression
 
 	// State
-	isServer      bool
-	receivedClose bool
-	sentClose     bool
+	isServer     bool
+	closeTimeout time.Duration
+
+	// keepAlive pings the peer, see SetKeepAlive
+	keepAlive *keepAlive
+
+	// Control frame handlers, called by the reading goroutine
+	pingHandler  func(appData []byte) error
+	pongHandler  func(appData []byte) error
+	closeHandler func(code int, text string) error
 
 	// Writing Specific
-	mask [4]byte
+	writeFrameSize    int
+	writeValidateUTF8 bool
+
+	// writePool holds the buffers frames are written from, see BufferPool
+	writePool       BufferPool
+	writeBufferSize int
+
+	// Reading Specific
+	readLimit      int64
+	readLength     int64
+	readFragmented bool
 
 	// ReaderWriter
 	pr  io.Reader
@@ -37,6 +65,8 @@ type Conn struct {
 
 // SetDeadline sets the read and write deadline on underlying network connection
 func (conn *Conn) SetDeadline(t time.Time) error {
+	conn.clearKeepAliveDeadline()
+
 	if conn, ok := conn.rwc.(net.Conn); ok {
 		return conn.SetDeadline(t)
 	}
@@ -46,6 +76,20 @@ func (conn *Conn) SetDeadline(t time.Time) error {
 
 // SetReadDeadline sets the read deadline on underlying network connection
 func (conn *Conn) SetReadDeadline(t time.Time) error {
+	conn.clearKeepAliveDeadline()
+
+	return conn.setReadDeadline(t)
+}
+
+// clearKeepAliveDeadline records that the read deadline is no longer the one
+// set by keepalive, so its expiry is not reported as a keepalive timeout
+func (conn *Conn) clearKeepAliveDeadline() {
+	if ka := conn.keepAlive; ka != nil {
+		atomic.StoreInt64(&ka.deadline, 0)
+	}
+}
+
+func (conn *Conn) setReadDeadline(t time.Time) error {
 	if conn, ok := conn.rwc.(net.Conn); ok {
 		return conn.SetReadDeadline(t)
 	}
@@ -62,34 +106,97 @@ func (conn *Conn) SetWriteDeadline(t time.Time) error {
 	return errSetDeadline
 }
 
-// Close closes the underlying network connection
-func (conn *Conn) Close() error {
-	if err := conn.Handler.CloseConnection(closeStatusNormal, "closing connection"); err != nil {
-		return err
+// Subprotocol returns the application protocol negotiated during the
+// handshake, or an empty string when no protocol was selected
+func (conn *Conn) Subprotocol() string {
+	return conn.subprotocol
+}
+
+// setExtensions stores the negotiated extensions in the order they are
+// applied to outgoing messages
+func (conn *Conn) setExtensions(extensions []ExtensionConn) {
+	conn.extensions = extensions
+	conn.rsv = claimedRSV(extensions)
+
+	for _, ext := range extensions {
+		if c, ok := ext.(*compression); ok {
+			conn.compression = c
+		}
+	}
+}
+
+// EnableWriteCompression enables or disables compression of outgoing
+// messages, it has no effect when permessage-deflate was not negotiated
+func (conn *Conn) EnableWriteCompression(enable bool) {
+	if conn.compression != nil {
+		conn.compression.writeEnabled = enable
 	}
+}
 
-	return conn.rwc.Close()
+// SetCompressionLevel sets the flate compression level for outgoing messages,
+// see compress/flate for valid levels
+func (conn *Conn) SetCompressionLevel(level int) error {
+	if conn.compression == nil {
+		return nil
+	}
+
+	return conn.compression.setLevel(level)
 }
 
-// Read read from the websocket
-func (conn *Conn) Read(b []byte) (n int, err error) {
-	n, err = conn.pr.Read(b)
+// Param returns the value of the path parameter name matched by the ServeMux
+// pattern, or an empty string when the pattern has no such parameter
+func (conn *Conn) Param(name string) string {
+	return conn.params[name]
+}
+
+// Params returns a copy of all path parameters matched by the ServeMux pattern
+func (conn *Conn) Params() map[string]string {
+	params := make(map[string]string, len(conn.params))
+
+	for name, value := range conn.params {
+		params[name] = value
+	}
 
-	// if err == io.EOF {
-	// 	opcode, reader, err = conn.Handler.NextReader()
+	return params
+}
 
-	// 	if err != nil {
-	// 		return n, err
-	// 	}
+// Close performs the closing handshake with a normal closure, it waits for
+// the peer's close frame up to the close timeout and closes the underlying
+// network connection. The peer's close frame is read by the goroutine reading
+// the connection, Close reads it itself when the connection was never read.
+// Sending after Close returns ErrCloseSent.
+func (conn *Conn) Close() error {
+	return conn.Handler.CloseConnection(CloseStatusNormal, "")
+}
 
-	// 	conn.pr = reader
+// SetCloseTimeout sets how long Close waits for the peer's close frame,
+// zero means the default of 5 seconds
+func (conn *Conn) SetCloseTimeout(timeout time.Duration) {
+	conn.closeTimeout = timeout
+}
 
-	// 	m, err := conn.Read(b[n:])
+// Read reads the payloads of the received messages as one stream, a message
+// read completely continues with the next message from NextReader
+func (conn *Conn) Read(b []byte) (n int, err error) {
+	for {
+		if conn.pr == nil {
+			if _, conn.pr, err = conn.Handler.NextReader(); err != nil {
+				return 0, err
+			}
+		}
 
-	// 	return m + n, err
-	// }
+		n, err = conn.pr.Read(b)
 
-	return n, err
+		if err != io.EOF {
+			return n, err
+		}
+
+		conn.pr = nil
+
+		if n > 0 {
+			return n, nil
+		}
+	}
 }
 
 // Write to the websocket connection
@@ -103,66 +210,134 @@ func (conn *Conn) Write(b []byte) (n int, err error) {
 	return len(b), nil
 }
 
-// Receive reads one message frame with an opcode Text / Binary from the websocket connection
-func (conn *Conn) Receive() (byte, []byte, error) {
-	return conn.Handler.ReadMessage()
+// EnableWriteUTF8Validation enables checking that text messages sent with
+// Send or Write are valid UTF-8, an invalid message is not sent and
+// ErrInvalidUTF8 is returned
+func (conn *Conn) EnableWriteUTF8Validation(enable bool) {
+	conn.writeValidateUTF8 = enable
 }
 
-// Send sends one message with opcode on the webscoket connection
-func (conn *Conn) Send(opcode byte, b []byte) error {
-	return conn.Handler.WriteMessage(opcode, b)
+// SetReadLimit sets the maximum size in bytes of a message read from the peer,
+// a larger message closes the connection with status 1009 and Receive returns
+// a *ReadLimitError. Zero means no limit.
+func (conn *Conn) SetReadLimit(limit int64) {
+	conn.readLimit = limit
 }
 
-// Flush flush the underlying buffered writer
-func (conn *Conn) Flush() error {
-	return conn.brw.Flush()
+// SetPingHandler sets the handler for pings received from the peer, it is
+// called by the reading goroutine with the ping's payload. The default handler
+// replies with a pong carrying the same payload, a nil h restores it. appData
+// is only valid until h returns.
+func (conn *Conn) SetPingHandler(h func(appData []byte) error) {
+	if h == nil {
+		h = func(appData []byte) error {
+			// Pings may still arrive while we wait for the peer's close
+			if err := conn.Send(PongMessage, appData); err != ErrCloseSent {
+				return err
+			}
+
+			return nil
+		}
+	}
+
+	conn.pingHandler = h
 }
 
-// Read n bytes from the buffered ReadWriter
-func (conn *Conn) read(n int) ([]byte, error) {
-	result, err := conn.brw.Peek(n)
+// SetPongHandler sets the handler for pongs received from the peer, it is
+// called by the reading goroutine with the pong's payload, e.g. to measure the
+// round trip time. The default handler does nothing, a nil h restores it.
+// appData is only valid until h returns.
+func (conn *Conn) SetPongHandler(h func(appData []byte) error) {
+	if h == nil {
+		h = func([]byte) error {
+			return nil
+		}
+	}
 
-	if err != nil {
-		fmt.Println("Error while peeking read buffer", err)
-		return result, err
+	conn.pongHandler = h
+}
+
+// SetCloseHandler sets the handler for the peer's close frame, it is called
+// by the reading goroutine with the peer's status code and reason. The closing
+// handshake echoes the status code afterwards unless h already sent a close
+// frame with Send(CloseMessage, FormatCloseMessage(code, text)). An error
+// returned by h is returned by Receive instead of the *CloseError. The default
+// handler does nothing, a nil h restores it.
+func (conn *Conn) SetCloseHandler(h func(code int, text string) error) {
+	if h == nil {
+		h = func(int, string) error {
+			return nil
+		}
 	}
 
-	_, err = conn.brw.Discard(n)
+	conn.closeHandler = h
+}
 
-	if err != nil {
-		fmt.Println("Error while discarding read buffer", err)
+// NextWriter returns a writer for a text or binary message of unknown length,
+// the message is sent on the connection when the writer is closed
+func (conn *Conn) NextWriter(opcode byte) (io.WriteCloser, error) {
+	return conn.Handler.NextWriter(opcode)
+}
+
+// SetWriteFrameSize sets the maximum payload size of the frames NextWriter
+// sends, larger messages are fragmented
+func (conn *Conn) SetWriteFrameSize(size int) error {
+	if size <= 0 {
+		return errInvalidFrameSize
 	}
 
-	return result, err
+	conn.writeFrameSize = size
+
+	return nil
 }
 
-// NewConn return a new websocket connection from a net.Conn
-func NewConn(conn net.Conn, bufrw *bufio.ReadWriter, request *http.Request) (c Conn, err error) {
-	var mask [4]byte
-	maskSlice := make([]byte, 4)
-	n, err := rand.Read(maskSlice)
+// Receive reads one message frame with an opcode Text / Binary from the websocket connection
+func (conn *Conn) Receive() (byte, []byte, error) {
+	return conn.Handler.ReadMessage()
+}
 
-	copy(mask[:], maskSlice[0:4])
+// ReceiveInto reads the next text or binary message into dst like Receive,
+// the message is returned in dst when it fits dst's capacity. Passing the
+// returned slice to the next call reuses it, so the steady state of a reader
+// allocates nothing.
+func (conn *Conn) ReceiveInto(dst []byte) (byte, []byte, error) {
+	return conn.Handler.ReadMessageInto(dst)
+}
 
-	if err != nil {
-		return c, err
+// Send sends one message with opcode on the webscoket connection
+func (conn *Conn) Send(opcode byte, b []byte) error {
+	return conn.Handler.WriteMessage(opcode, b)
+}
+
+// Flush flush the underlying buffered writer
+func (conn *Conn) Flush() error {
+	// With a write buffer pool frames are not buffered
+	if conn.brw.Writer == nil {
+		return nil
 	}
 
-	if n != 4 {
-		return c, errors.New("Expected 4 random bytes for the mask")
+	return conn.brw.Flush()
+}
+
+// NewConn return a new websocket connection from a net.Conn, request is the
+// handshake request of a server side connection and nil for a client
+func NewConn(conn net.Conn, bufrw *bufio.ReadWriter, request *http.Request) (*Conn, error) {
+	result := &Conn{
+		rwc:            conn,
+		request:        request,
+		isServer:       request != nil,
+		writeFrameSize: defaultWriteFrameSize,
+		brw:            bufrw,
 	}
 
-	result := Conn{
-		rwc:           conn,
-		request:       request,
-		isServer:      request != nil,
-		receivedClose: false,
-		sentClose:     false,
-		mask:          mask,
-		brw:           bufrw,
+	if bufrw.Writer != nil {
+		result.writeBufferSize = bufrw.Writer.Size()
 	}
 
-	result.Handler = NewFrameSpecHandler(&result)
+	result.Handler = NewFrameSpecHandler(result)
+	result.SetPingHandler(nil)
+	result.SetPongHandler(nil)
+	result.SetCloseHandler(nil)
 
 	return result, nil
 }
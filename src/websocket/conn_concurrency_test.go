@@ -0,0 +1,328 @@
+package websocket
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readMessages reads frames from r until count data messages were received,
+// the control frames read in between are returned separately
+func readMessages(r io.Reader, count int) (messages [][]byte, control []rawFrame, err error) {
+	var message []byte
+
+	for len(messages) < count {
+		frame, err := readRawFrame(r)
+
+		if err != nil {
+			return messages, control, err
+		}
+
+		fh, payload := frame.header, frame.payload
+
+		if isControlFrameOpcode(fh.Opcode()) {
+			control = append(control, frame)
+			continue
+		}
+
+		if fh.Opcode() != ContinuationFrame && message != nil {
+			return messages, control, fmt.Errorf("message interleaved with opcode %d", fh.Opcode())
+		}
+
+		message = append(message, payload...)
+
+		if fh.Final() {
+			messages = append(messages, message)
+			message = nil
+		} else if message == nil {
+			message = []byte{}
+		}
+	}
+
+	return messages, control, nil
+}
+
+func TestConcurrentSend(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	const writers, count = 8, 50
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			message := bytes.Repeat([]byte{byte('a' + i)}, 100+i)
+
+			for j := 0; j < count; j++ {
+				assert.Nil(t, ws.Send(BinaryMessage, message))
+				assert.Nil(t, ws.Send(PingMessage, []byte{byte(i)}))
+			}
+		}(i)
+	}
+
+	messages, control, err := readMessages(src, writers*count)
+
+	assert.Nil(t, err)
+
+	// Read the pings sent after the last message
+	for err == nil && len(control) < writers*count {
+		var frames []rawFrame
+
+		frames, err = readRawFrames(src)
+		control = append(control, frames...)
+	}
+
+	wg.Wait()
+
+	assert.Nil(t, err)
+
+	for _, frame := range control {
+		assert.Equal(t, byte(PingMessage), frame.header.Opcode())
+	}
+
+	// Every message arrives intact
+	for _, message := range messages {
+		if assert.True(t, len(message) >= 100) {
+			assert.Equal(t, bytes.Repeat(message[:1], len(message)), message)
+			assert.Equal(t, 100+int(message[0]-'a'), len(message))
+		}
+	}
+}
+
+func TestConcurrentNextWriter(t *testing.T) {
+	src, ws, err := wsPipe(nil)
+
+	assert.Nil(t, err)
+	assert.Nil(t, ws.SetWriteFrameSize(3))
+
+	const writers = 8
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			w, err := ws.NextWriter(TextMessage)
+
+			if !assert.Nil(t, err) {
+				return
+			}
+
+			for j := 0; j < 10; j++ {
+				fmt.Fprintf(w, "%d", i)
+			}
+
+			assert.Nil(t, w.Close())
+		}(i)
+	}
+
+	messages, _, err := readMessages(src, writers)
+
+	wg.Wait()
+
+	assert.Nil(t, err)
+
+	// The fragments of a message are never interleaved with another message
+	for _, message := range messages {
+		assert.Equal(t, bytes.Repeat(message[:1], 10), message)
+	}
+}
+
+func TestPongBetweenFragments(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+	assert.Nil(t, ws.SetWriteFrameSize(4))
+
+	// The reading goroutine replies to pings while a message is written
+	go ws.Receive()
+
+	w, err := ws.NextWriter(BinaryMessage)
+
+	assert.Nil(t, err)
+
+	resume := make(chan struct{})
+	done := make(chan error, 1)
+
+	go func() {
+		// The second write sends the first fragment
+		w.Write([]byte("abcd"))
+		w.Write([]byte("abcd"))
+
+		<-resume
+
+		w.Write([]byte("abcd"))
+		done <- w.Close()
+	}()
+
+	first, err := readRawFrames(io.LimitReader(src, 6))
+
+	assert.Equal(t, io.EOF, err)
+
+	if assert.Equal(t, 1, len(first)) {
+		assert.False(t, first[0].header.Final())
+		assert.Equal(t, "abcd", string(first[0].payload))
+	}
+
+	go writeTestFrame(src, true, true, PingMessage, []byte("ping"))
+
+	// The pong is sent while the message writer is still open
+	pong, err := readRawFrames(src)
+
+	assert.Nil(t, err)
+
+	if assert.Equal(t, 1, len(pong)) {
+		assert.Equal(t, byte(PongMessage), pong[0].header.Opcode())
+		assert.Equal(t, "ping", string(pong[0].payload))
+	}
+
+	close(resume)
+
+	messages, _, err := readMessages(src, 1)
+
+	assert.Nil(t, err)
+	assert.Nil(t, <-done)
+
+	if assert.Equal(t, 1, len(messages)) {
+		assert.Equal(t, "abcdabcd", string(messages[0]))
+	}
+}
+
+func TestConcurrentClose(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	ws.SetCloseTimeout(2 * time.Second)
+
+	const sent = 20
+
+	// The peer sends messages and echoes our close frame once they are sent
+
+	peerSent := make(chan struct{})
+
+	go func() {
+		defer close(peerSent)
+
+		for i := 0; i < sent; i++ {
+			writeTestFrame(src, true, true, TextMessage, []byte("peer"))
+		}
+	}()
+
+	received := make(chan struct{}, 1)
+	afterClose := make(chan byte, 100)
+
+	go func() {
+		defer close(afterClose)
+
+		closeSeen := false
+
+		for {
+			frame, err := readRawFrame(src)
+
+			if err != nil {
+				return
+			}
+
+			fh, payload := frame.header, frame.payload
+
+			select {
+			case received <- struct{}{}:
+			default:
+			}
+
+			if closeSeen {
+				afterClose <- fh.Opcode()
+				continue
+			}
+
+			if fh.Opcode() == CloseMessage {
+				closeSeen = true
+
+				<-peerSent
+				writeTestFrame(src, true, true, CloseMessage, payload)
+			}
+		}
+	}()
+
+	// Reader loop
+
+	readErr := make(chan error, 1)
+
+	go func() {
+		for {
+			if _, _, err := ws.Receive(); err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	// Writer loops, with single frame messages and fragmented messages
+
+	var writers sync.WaitGroup
+
+	writeErrs := make(chan error, 2)
+
+	writers.Add(2)
+
+	go func() {
+		defer writers.Done()
+
+		for {
+			if err := ws.Send(TextMessage, []byte("hello")); err != nil {
+				writeErrs <- err
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer writers.Done()
+
+		for {
+			w, err := ws.NextWriter(BinaryMessage)
+
+			if err == nil {
+				w.Write(bytes.Repeat([]byte("x"), 2*defaultWriteFrameSize))
+				err = w.Close()
+			}
+
+			if err != nil {
+				writeErrs <- err
+				return
+			}
+		}
+	}()
+
+	<-received
+
+	assert.Nil(t, ws.Close())
+	assert.Equal(t, &CloseError{Code: CloseStatusNormal}, <-readErr)
+
+	writers.Wait()
+	close(writeErrs)
+
+	for err := range writeErrs {
+		assert.Equal(t, ErrCloseSent, err)
+	}
+
+	for opcode := range afterClose {
+		t.Error("Expected no frame after the close frame", opcode)
+	}
+}
@@ -6,11 +6,11 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"testing"
-	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -48,6 +48,10 @@ func (sp *FrameHandlerStub) ReadMessage() (byte, []byte, error) {
 	return 0x0, []byte{}, nil
 }
 
+func (sp *FrameHandlerStub) ReadMessageInto(dst []byte) (byte, []byte, error) {
+	return 0x0, dst[:0], nil
+}
+
 func (sp *FrameHandlerStub) WriteMessage(byte, []byte) error {
 	return nil
 }
@@ -60,7 +64,7 @@ func (sp *FrameHandlerStub) NextReader() (byte, io.Reader, error) {
 	return 0x0, sp.getPR(), nil
 }
 
-func (sp *FrameHandlerStub) NextWriter(opcode byte, pl int64) (io.Writer, error) {
+func (sp *FrameHandlerStub) NextWriter(opcode byte) (io.WriteCloser, error) {
 	return nil, nil
 }
 
@@ -73,20 +77,69 @@ func NewFrameHandlerStub() *FrameHandlerStub {
 	}
 }
 
-func wsPipe() (src net.Conn, ws Conn, err error) {
+// wsPipe returns a server side connection when request is not nil and a
+// client side connection, which masks its frames, otherwise
+func wsPipe(request *http.Request) (src net.Conn, ws *Conn, err error) {
 	src, dest := net.Pipe()
 
-	r := bufio.NewReader(dest)
-	w := bufio.NewWriter(dest)
-	rw := bufio.NewReadWriter(r, w)
-
-	request := &http.Request{}
-
+	rw := bufio.NewReadWriter(bufio.NewReader(dest), bufio.NewWriter(dest))
 	ws, err = NewConn(dest, rw, request)
 
 	return src, ws, err
 }
 
+// testMaskKey masks the frames written by writeTestFrame
+var testMaskKey = [4]byte{0x12, 0x34, 0x56, 0x78}
+
+// writeTestFrame writes a frame to w, masked frames are sent by a client to a
+// server and unmasked frames by a server to a client
+func writeTestFrame(w io.Writer, masked, final bool, opcode byte, payload []byte) {
+	fh := NewFrameHeader(final, opcode, masked, testMaskKey, int64(len(payload)))
+
+	if masked {
+		payload = append([]byte(nil), payload...)
+		mask(0, testMaskKey, payload)
+	}
+
+	w.Write(AppendFrameHeader(nil, fh))
+	w.Write(payload)
+}
+
+type rawFrame struct {
+	header  FrameHeader
+	payload []byte
+}
+
+// readRawFrame reads the next frame from r without interpreting it
+func readRawFrame(r io.Reader) (rawFrame, error) {
+	fh, err := ReadFrameHeader(r)
+
+	if err != nil {
+		return rawFrame{}, err
+	}
+
+	payload, err := ioutil.ReadAll(NewPayloadReader(io.LimitReader(r, fh.PayloadLength()), fh))
+
+	return rawFrame{fh, payload}, err
+}
+
+// readRawFrames reads frames from r until the final frame of a message
+func readRawFrames(r io.Reader) (frames []rawFrame, err error) {
+	for {
+		frame, err := readRawFrame(r)
+
+		if err != nil {
+			return frames, err
+		}
+
+		frames = append(frames, frame)
+
+		if frame.header.Final() {
+			return frames, nil
+		}
+	}
+}
+
 func TestConnRead(t *testing.T) {
 	handler := NewFrameHandlerStub()
 
@@ -131,7 +184,7 @@ func TestConnWrite(t *testing.T) {
 func TestWSConnRead(t *testing.T) {
 	// 1: Create test connections src -> dest
 
-	src, ws, err := wsPipe()
+	src, ws, err := wsPipe(&http.Request{})
 
 	assert.Nil(t, err)
 
@@ -139,9 +192,9 @@ func TestWSConnRead(t *testing.T) {
 
 	write := func(msg string) {
 		payload := []byte(msg)
-		fh := NewFrameHeader(false, TextMessage, true, [4]byte{0x5, 0xa, 0xd, 0x1}, int64(len(payload)))
+		fh := NewFrameHeader(true, TextMessage, true, [4]byte{0x5, 0xa, 0xd, 0x1}, int64(len(payload)))
 
-		_, err = src.Write(fh.toByteSlice())
+		_, err := src.Write(AppendFrameHeader(nil, fh))
 
 		assert.Nil(t, err)
 
@@ -151,11 +204,10 @@ func TestWSConnRead(t *testing.T) {
 		assert.Nil(t, err)
 	}
 
-	go write("Hello i am testing this socket with a message!")
-
-	time.Sleep(500 * time.Millisecond)
-
-	go write("Second message!")
+	go func() {
+		write("Hello i am testing this socket with a message!")
+		write("Second message!")
+	}()
 
 	// 3 : Read from websocket conn
 
@@ -189,7 +241,7 @@ func TestWSConnRead(t *testing.T) {
 }
 
 func TestWSConnWrite(t *testing.T) {
-	consumer, ws, err := wsPipe()
+	consumer, ws, err := wsPipe(&http.Request{})
 
 	assert.Nil(t, err)
 
@@ -202,29 +254,24 @@ func TestWSConnWrite(t *testing.T) {
 	}
 
 	read := func(expectedOpCode byte) {
-		header := make([]byte, 2)
+		frames, err := readRawFrames(consumer)
 
-		_, err = consumer.Read(header)
-
-		assert.Nil(t, err)
-
-		opcode := header[0] & 0xf
-
-		assert.Equal(t, expectedOpCode, opcode, "Unexpected opcode for frame")
+		if assert.Nil(t, err) {
+			assert.Equal(t, expectedOpCode, frames[0].header.Opcode(), "Unexpected opcode for frame")
+		}
 	}
 
-	go write(TextMessage, []byte("Hello world!"))
-
-	time.Sleep(500 * time.Millisecond)
-
-	go write(BinaryMessage, []byte{0x1, 0x2, 0x3, 0x4})
+	go func() {
+		write(TextMessage, []byte("Hello world!"))
+		write(BinaryMessage, []byte{0x1, 0x2, 0x3, 0x4})
+	}()
 
 	read(TextMessage)
 	read(BinaryMessage)
 }
 
 func TestWSWriteMediumMessage(t *testing.T) {
-	conn, ws, err := wsPipe()
+	conn, ws, err := wsPipe(&http.Request{})
 
 	// 1 : Generate large byte slice of length 2^15
 
@@ -252,7 +299,7 @@ func TestWSWriteMediumMessage(t *testing.T) {
 	read := func(l int) []byte {
 		buf := make([]byte, l)
 
-		n, err := conn.Read(buf)
+		n, err := io.ReadFull(conn, buf)
 
 		assert.Nil(t, err)
 		assert.Equal(t, l, n, "Read less bytes than expected")
@@ -273,7 +320,7 @@ func TestWSWriteMediumMessage(t *testing.T) {
 
 	byte34 := read(2)
 
-	payloadLength := int(binary.LittleEndian.Uint16(byte34))
+	payloadLength := int(binary.BigEndian.Uint16(byte34))
 
 	assert.Equal(t, l, payloadLength, "Wrong payload length parsed")
 
@@ -283,13 +330,13 @@ func TestWSWriteMediumMessage(t *testing.T) {
 }
 
 func TestWSWriteLargeMessage(t *testing.T) {
-	conn, ws, err := wsPipe()
+	conn, ws, err := wsPipe(&http.Request{})
 
-	// 1 : Generate a writer for a large payload size
+	// 1 : Generate a writer for a message of unknown length
 
 	var pl int64 = 1 << 20
 
-	wr, err := ws.Handler.NextWriter(BinaryMessage, pl)
+	wr, err := ws.NextWriter(BinaryMessage)
 
 	if err != nil {
 		t.Error("Failed to create writer for frame", err)
@@ -304,63 +351,63 @@ func TestWSWriteLargeMessage(t *testing.T) {
 		written, err := io.CopyBuffer(wr, limitRand, nil)
 
 		assert.Nil(t, err)
+		assert.Nil(t, wr.Close())
 
 		log.Println("written", written)
-
-		ws.Flush()
 	}
 
 	go write()
 
-	// 3 : Read from conn
+	// 3 : Read the frames from conn until the final frame
 
-	read := func(l int) []byte {
-		buf := make([]byte, l)
+	var received int64
+	var frames int
 
-		n, err := conn.Read(buf)
+	for {
+		fh, err := ReadFrameHeader(conn)
 
-		assert.Equal(t, n, l, "Expected to read l bytes")
-		assert.Nil(t, err)
+		if !assert.Nil(t, err) {
+			return
+		}
 
-		return buf
-	}
+		if frames == 0 {
+			assert.Equal(t, byte(BinaryMessage), fh.Opcode(), "Expected the first frame to carry the opcode")
+		} else {
+			assert.Equal(t, byte(ContinuationFrame), fh.Opcode(), "Expected a continuation frame")
+		}
 
-	header := read(10)
+		assert.True(t, fh.PayloadLength() <= defaultWriteFrameSize, "Expected frames of at most the write frame size")
 
-	payloadLength := binary.LittleEndian.Uint64(header[2:])
+		n, err := io.CopyN(ioutil.Discard, conn, fh.PayloadLength())
 
-	assert.Equal(t, payloadLength, pl, "Expected to receive pyaload length amount of bytes")
+		assert.Nil(t, err)
 
-	bytesRem := int64(payloadLength)
+		received += n
+		frames++
 
-	buf := make([]byte, 30*1024)
-	for {
-		if bytesRem <= 0 {
+		if fh.Final() {
 			break
 		}
-
-		n, err := conn.Read(buf)
-
-		assert.Nil(t, err)
-
-		bytesRem -= int64(n)
 	}
 
+	assert.Equal(t, pl, received, "Expected to receive pyaload length amount of bytes")
+	assert.Equal(t, int(pl/defaultWriteFrameSize), frames)
+
 	log.Println("Read all from connection")
 }
 
 func TestPingControlMessage(t *testing.T) {
-	conn, ws, err := wsPipe()
+	conn, ws, err := wsPipe(&http.Request{})
 
 	assert.Nil(t, err)
 
 	// Write PING message
 
 	pl := []byte("This is a ping message!")
-	fh := NewFrameHeader(false, PingMessage, true, [4]byte{0x0, 0x0, 0x0, 0x0}, int64(len(pl)))
+	fh := NewFrameHeader(true, PingMessage, true, [4]byte{0x0, 0x0, 0x0, 0x0}, int64(len(pl)))
 
 	write := func() {
-		conn.Write(fh.toByteSlice())
+		conn.Write(AppendFrameHeader(nil, fh))
 		conn.Write(pl)
 	}
 
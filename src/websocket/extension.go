@@ -0,0 +1,154 @@
+package websocket
+
+import (
+	"io"
+	"sort"
+)
+
+// Reserved header bits that can be claimed by an extension, see
+// rfc6455#section-5.2
+const (
+	RSV1 = rsv1BitMask
+	RSV2 = rsv2BitMask
+	RSV3 = rsv3BitMask
+)
+
+// ExtensionParams holds the parameters of one Sec-WebSocket-Extensions
+// element, a parameter without a value maps to an empty string
+type ExtensionParams map[string]string
+
+// Extension is a websocket extension (rfc6455#section-9) that participates
+// in the offer/accept negotiation of the opening handshake
+type Extension interface {
+	// Name returns the extension token used in Sec-WebSocket-Extensions
+	Name() string
+
+	// Offer returns the parameters a client offers to the server
+	Offer() ExtensionParams
+
+	// Accept is called by a server for a client offer of this extension, it
+	// returns the response parameters and the state for the new connection.
+	// ok is false when the server declines the offer.
+	Accept(offer ExtensionParams) (response ExtensionParams, conn ExtensionConn, ok bool)
+
+	// Configure is called by a client with the parameters of the server's
+	// response, an error fails the handshake
+	Configure(response ExtensionParams) (ExtensionConn, error)
+}
+
+// ExtensionConn is the per connection state of a negotiated extension
+type ExtensionConn interface {
+	// RSV returns the reserved bits (RSV1, RSV2, RSV3) claimed by the extension
+	RSV() byte
+
+	// NewReader transforms the payload of an incoming data message, header is
+	// the header of the first frame of the message
+	NewReader(header FrameHeader, r io.Reader) (io.Reader, error)
+
+	// NewWriter transforms the payload of an outgoing data message, it may set
+	// its claimed reserved bits on header which is the first frame's header
+	NewWriter(header *FrameHeader, w io.WriteCloser) (io.WriteCloser, error)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func formatExtension(name string, params ExtensionParams) string {
+	names := make([]string, 0, len(params))
+
+	for param := range params {
+		names = append(names, param)
+	}
+
+	sort.Strings(names)
+
+	result := name
+
+	for _, param := range names {
+		result += "; " + param
+
+		if value := params[param]; value != "" {
+			result += "=" + value
+		}
+	}
+
+	return result
+}
+
+func findExtension(extensions []Extension, name string) Extension {
+	for _, ext := range extensions {
+		if ext.Name() == name {
+			return ext
+		}
+	}
+
+	return nil
+}
+
+func claimedRSV(conns []ExtensionConn) (rsv byte) {
+	for _, conn := range conns {
+		rsv |= conn.RSV()
+	}
+
+	return rsv
+}
+
+// acceptExtensions negotiates the client's offers in order, the first
+// acceptable offer of each extension is used
+func acceptExtensions(supported []Extension, offers []extension) (response []string, conns []ExtensionConn) {
+	accepted := map[string]bool{}
+
+	for _, offer := range offers {
+		ext := findExtension(supported, offer.name)
+
+		if ext == nil || accepted[offer.name] {
+			continue
+		}
+
+		params, conn, ok := ext.Accept(ExtensionParams(offer.params))
+
+		// Two extensions can never claim the same reserved bit
+		if !ok || conn.RSV()&claimedRSV(conns) != 0 {
+			continue
+		}
+
+		accepted[offer.name] = true
+		response = append(response, formatExtension(offer.name, params))
+		conns = append(conns, conn)
+	}
+
+	return response, conns
+}
+
+// configureExtensions validates the server's response against our offers
+func configureExtensions(offered []Extension, responses []extension) (conns []ExtensionConn, err error) {
+	configured := map[string]bool{}
+
+	for _, response := range responses {
+		ext := findExtension(offered, response.name)
+
+		if ext == nil || configured[response.name] {
+			return nil, ErrBadWebSocketExtension
+		}
+
+		conn, err := ext.Configure(ExtensionParams(response.params))
+
+		if err != nil {
+			return nil, err
+		}
+
+		if conn.RSV()&claimedRSV(conns) != 0 {
+			return nil, ErrBadWebSocketExtension
+		}
+
+		configured[response.name] = true
+		conns = append(conns, conn)
+	}
+
+	return conns, nil
+}
@@ -0,0 +1,211 @@
+package websocket
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// xorExtension is a test extension that xors data messages with a key and
+// marks them with RSV2
+type xorExtension struct {
+	key byte
+}
+
+func (ext xorExtension) Name() string {
+	return "x-xor"
+}
+
+func (ext xorExtension) Offer() ExtensionParams {
+	return ExtensionParams{"key": string('a' + ext.key)}
+}
+
+func (ext xorExtension) Accept(offer ExtensionParams) (ExtensionParams, ExtensionConn, bool) {
+	if offer["key"] == "" {
+		return nil, nil, false
+	}
+
+	return offer, &xorConn{offer["key"][0] - 'a'}, true
+}
+
+func (ext xorExtension) Configure(response ExtensionParams) (ExtensionConn, error) {
+	if response["key"] != string('a'+ext.key) {
+		return nil, ErrBadWebSocketExtension
+	}
+
+	return &xorConn{ext.key}, nil
+}
+
+type xorConn struct {
+	key byte
+}
+
+func (c *xorConn) RSV() byte {
+	return RSV2
+}
+
+func (c *xorConn) NewReader(header FrameHeader, r io.Reader) (io.Reader, error) {
+	if header.RSV()&RSV2 == 0 {
+		return r, nil
+	}
+
+	return &xorReader{r, c.key}, nil
+}
+
+func (c *xorConn) NewWriter(header *FrameHeader, w io.WriteCloser) (io.WriteCloser, error) {
+	header.SetRSV(header.RSV() | RSV2)
+
+	return &xorWriter{w, c.key}, nil
+}
+
+type xorReader struct {
+	r   io.Reader
+	key byte
+}
+
+func (r *xorReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+
+	for i := range b[:n] {
+		b[i] ^= r.key
+	}
+
+	return n, err
+}
+
+type xorWriter struct {
+	io.WriteCloser
+	key byte
+}
+
+func (w *xorWriter) Write(b []byte) (int, error) {
+	p := make([]byte, len(b))
+
+	for i := range b {
+		p[i] = b[i] ^ w.key
+	}
+
+	return w.WriteCloser.Write(p)
+}
+
+func TestAcceptExtensions(t *testing.T) {
+	header := http.Header{"Sec-Websocket-Extensions": {"x-xor, x-xor; key=c, permessage-deflate, x-other"}}
+
+	response, conns := acceptExtensions(withCompression(true, []Extension{xorExtension{}}), parseExtensions(header))
+
+	assert.Equal(t, []string{"x-xor; key=c", "permessage-deflate"}, response)
+	assert.Equal(t, 2, len(conns))
+	assert.Equal(t, byte(RSV1|RSV2), claimedRSV(conns))
+}
+
+func TestConfigureExtensionsNotOffered(t *testing.T) {
+	header := http.Header{"Sec-Websocket-Extensions": {"x-xor; key=a, x-other"}}
+
+	_, err := configureExtensions([]Extension{xorExtension{}}, parseExtensions(header))
+
+	assert.Equal(t, ErrBadWebSocketExtension, err, "Expected extension we didn't offer to fail")
+}
+
+func TestWSWriteExtension(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	ws.Handler.(*FrameSpecHandler).conn.setExtensions([]ExtensionConn{&xorConn{0x1}})
+
+	go ws.Send(TextMessage, []byte("abc"))
+
+	frame := make([]byte, 5)
+	_, err = io.ReadFull(src, frame)
+
+	assert.Nil(t, err)
+	assert.Equal(t, byte(RSV2), frame[0]&(RSV1|RSV2|RSV3), "Expected extension to set RSV2")
+	assert.Equal(t, []byte("`cb"), frame[2:])
+}
+
+func TestWSReadExtension(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	ws.Handler.(*FrameSpecHandler).conn.setExtensions([]ExtensionConn{&xorConn{0x1}})
+
+	fh := NewFrameHeader(true, TextMessage, true, testMaskKey, 3)
+	fh.SetRSV(RSV2)
+
+	payload := []byte("`cb")
+	mask(0, testMaskKey, payload)
+
+	go func() {
+		src.Write(AppendFrameHeader(nil, fh))
+		src.Write(payload)
+	}()
+
+	_, reader, err := ws.Handler.NextReader()
+
+	assert.Nil(t, err)
+
+	msg, err := ioutil.ReadAll(reader)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", string(msg))
+}
+
+func TestWSRejectUnclaimedRSV(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	ws.Handler.(*FrameSpecHandler).conn.setExtensions([]ExtensionConn{&xorConn{0x1}})
+
+	fh := NewFrameHeader(true, TextMessage, true, testMaskKey, 0)
+	fh.SetRSV(RSV2 | RSV3)
+
+	go src.Write(AppendFrameHeader(nil, fh))
+
+	status := make(chan int, 1)
+
+	go func() {
+		status <- closeStatus(t, src)
+	}()
+
+	_, _, err = ws.Handler.NextReader()
+
+	assert.Equal(t, ErrBadRSV, err)
+	assert.Equal(t, CloseStatusProtocolError, <-status)
+}
+
+func TestDialExtensions(t *testing.T) {
+	upgrader := &Upgrader{Extensions: []Extension{xorExtension{}}}
+	negotiated := make(chan []ExtensionConn, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+
+		if err != nil {
+			negotiated <- nil
+			return
+		}
+
+		negotiated <- conn.extensions
+		conn.rwc.Close()
+	}))
+	defer server.Close()
+
+	dialer := &Dialer{Extensions: []Extension{xorExtension{key: 0x2}}}
+	conn, err := dialer.Dial(wsURL(server))
+
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	defer conn.rwc.Close()
+
+	assert.Equal(t, []ExtensionConn{&xorConn{0x2}}, <-negotiated)
+	assert.Equal(t, []ExtensionConn{&xorConn{0x2}}, conn.extensions)
+	assert.Equal(t, byte(RSV2), conn.rsv)
+}
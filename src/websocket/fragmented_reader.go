@@ -4,27 +4,57 @@ import (
 	"io"
 )
 
+// fragmentReader reads a fragmented message, when the current fragment R is
+// read completely the next fragment is read from H
 type fragmentReader struct {
 	R io.Reader
 	H FrameHandler
+
+	// final is set once R is the final fragment of the message
+	final bool
 }
 
+// Read reads from the current fragment and continues on the following
+// fragments until b is full, fragments are read in a loop so any number of
+// them takes constant stack space
 func (r *fragmentReader) Read(b []byte) (n int, err error) {
-	n, err = r.R.Read(b)
+	for {
+		m, err := r.R.Read(b[n:])
+		n += m
+
+		if r.final || !r.exhausted(m, b[n-m:], err) {
+			return n, err
+		}
 
-	if err == io.EOF || n < len(b) {
 		opcode, reader, err := r.H.NextReader()
 
-		// If this was the last frame
-		if opcode != ContinuationFrame {
+		if err != nil {
 			return n, err
 		}
 
+		// Only continuation frames can follow a fragment
+		if opcode != ContinuationFrame {
+			return n, ErrBadFrame
+		}
+
 		// Otherwise we continue the read on the next fragment
 		r.R = reader
 
-		return r.Read(b[n:])
+		if pr, ok := reader.(*payloadReader); ok {
+			r.final = pr.header.final
+		}
+	}
+}
+
+// exhausted reports if the current fragment has been read completely
+func (r *fragmentReader) exhausted(n int, b []byte, err error) bool {
+	if err == io.EOF {
+		return true
+	}
+
+	if pr, ok := r.R.(PayloadReader); ok {
+		return err == nil && pr.Remaining() == 0
 	}
 
-	return n, err
+	return err == nil && n < len(b)
 }
@@ -1,7 +1,11 @@
 package websocket
 
 import (
+	"bufio"
 	"io"
+	"net"
+	"net/http"
+	"runtime/debug"
 	"testing"
 
 	"bytes"
@@ -19,6 +23,10 @@ func (h *handlerMock) ReadMessage() (byte, []byte, error) {
 	return 0x0, []byte{}, nil
 }
 
+func (h *handlerMock) ReadMessageInto(dst []byte) (byte, []byte, error) {
+	return 0x0, dst[:0], nil
+}
+
 func (h *handlerMock) WriteMessage(byte, []byte) error {
 	return nil
 }
@@ -32,10 +40,10 @@ func (h *handlerMock) NextReader() (byte, io.Reader, error) {
 	return byte(args.Int(0)), args.Get(1).(io.Reader), args.Error(2)
 }
 
-func (h *handlerMock) NextWriter(byte, int64) (io.Writer, error) {
+func (h *handlerMock) NextWriter(byte) (io.WriteCloser, error) {
 	buf := make([]byte, 1024)
 
-	return bytes.NewBuffer(buf), nil
+	return nopWriteCloser{bytes.NewBuffer(buf)}, nil
 }
 
 func TestFragmentReaderOneFrameExactly(t *testing.T) {
@@ -46,7 +54,7 @@ func TestFragmentReaderOneFrameExactly(t *testing.T) {
 
 	mock.On("NextReader").Return(0x0, strings.NewReader(testPayload), nil)
 
-	fr := fragmentReader{reader, mock}
+	fr := fragmentReader{R: reader, H: mock}
 
 	buf := make([]byte, len(testPayload))
 	fr.Read(buf)
@@ -61,7 +69,7 @@ func TestFragmentedReaderTwoFramesExactly(t *testing.T) {
 
 	mock.On("NextReader").Return(0x0, strings.NewReader(testPayload), nil)
 
-	fr := fragmentReader{reader, mock}
+	fr := fragmentReader{R: reader, H: mock}
 
 	buf := make([]byte, len(testPayload)*2)
 	fr.Read(buf)
@@ -77,7 +85,7 @@ func TestFragmentReaderOneFramesPlusABit(t *testing.T) {
 
 	mock.On("NextReader").Return(0x0, strings.NewReader(testPayload), nil)
 
-	fr := fragmentReader{reader, mock}
+	fr := fragmentReader{R: reader, H: mock}
 
 	buf := make([]byte, len(testPayload)+3)
 	fr.Read(buf)
@@ -91,12 +99,10 @@ func TestFragmentedReaderTwoFramesPlusABit(t *testing.T) {
 	reader := strings.NewReader(testPayload)
 	mock := &handlerMock{}
 
-	var nextReader io.Reader
-	mock.On("NextReader").Run(func(args Arguments) {
-		nextReader = strings.NewReader(testPayload)
-	}).Return(0x0, nextReader, nil)
+	mock.On("NextReader").Return(0x0, strings.NewReader(testPayload), nil).Once()
+	mock.On("NextReader").Return(0x0, strings.NewReader(testPayload), nil).Once()
 
-	fr := fragmentReader{reader, mock}
+	fr := fragmentReader{R: reader, H: mock}
 
 	buf := make([]byte, len(testPayload)*2+3)
 	fr.Read(buf)
@@ -111,3 +117,107 @@ func TestFragmentedReaderMultipleFrame(t *testing.T) {
 func TestFragmentReaderMultipleFramesAtOnce(t *testing.T) {
 
 }
+
+func TestFragmentedMessageRoundTrip(t *testing.T) {
+	client, server := net.Pipe()
+
+	sender, err := NewConn(client, bufio.NewReadWriter(bufio.NewReader(client), bufio.NewWriter(client)), nil)
+	assert.Nil(t, err)
+
+	receiver, err := NewConn(server, bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)), &http.Request{})
+	assert.Nil(t, err)
+
+	sender.SetWriteFrameSize(3)
+
+	go func() {
+		w, _ := sender.NextWriter(TextMessage)
+
+		io.WriteString(w, "fragmented message")
+		w.Close()
+
+		sender.Send(BinaryMessage, []byte{0x1, 0x2})
+	}()
+
+	opcode, message, err := receiver.Receive()
+
+	assert.Nil(t, err)
+	assert.Equal(t, byte(TextMessage), opcode)
+	assert.Equal(t, "fragmented message", string(message))
+
+	// The next message must not be consumed by the fragmented one
+
+	opcode, message, err = receiver.Receive()
+
+	assert.Nil(t, err)
+	assert.Equal(t, byte(BinaryMessage), opcode)
+	assert.Equal(t, []byte{0x1, 0x2}, message)
+}
+
+func TestFragmentedMessageInterleavedPing(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	pong := make(chan string, 1)
+
+	go func() {
+		writeTestFrame(src, true, false, TextMessage, []byte("hello "))
+		writeTestFrame(src, true, true, PingMessage, []byte("ping"))
+
+		frames, _ := readRawFrames(src)
+		pong <- string(frames[0].payload)
+
+		writeTestFrame(src, true, true, ContinuationFrame, []byte("world"))
+	}()
+
+	_, message, err := ws.Receive()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "hello world", string(message))
+	assert.Equal(t, "ping", <-pong)
+}
+
+func TestFragmentedMessageUnexpectedContinuation(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	go writeTestFrame(src, true, true, ContinuationFrame, []byte("orphan"))
+
+	status := make(chan int, 1)
+
+	go func() {
+		status <- closeStatus(t, src)
+	}()
+
+	_, _, err = ws.Receive()
+
+	assert.Equal(t, ErrUnexpectedContinue, err)
+	assert.Equal(t, CloseStatusProtocolError, <-status)
+}
+
+func TestFragmentedMessageManyEmptyFragments(t *testing.T) {
+	src, ws, err := wsPipe(nil)
+
+	assert.Nil(t, err)
+
+	// Reading fragments must not grow the stack with their number
+	defer debug.SetMaxStack(debug.SetMaxStack(1 << 20))
+
+	var frames bytes.Buffer
+
+	writeTestFrame(&frames, false, false, TextMessage, []byte("many "))
+
+	for i := 0; i < 100000; i++ {
+		writeTestFrame(&frames, false, false, ContinuationFrame, nil)
+	}
+
+	writeTestFrame(&frames, false, true, ContinuationFrame, []byte("fragments"))
+
+	go src.Write(frames.Bytes())
+
+	_, message, err := ws.Receive()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "many fragments", string(message))
+}
@@ -2,6 +2,7 @@ package websocket
 
 import (
 	"encoding/binary"
+	"io"
 )
 
 //  0                   1                   2                   3
@@ -42,62 +43,153 @@ func NewFrameHeader(final bool, opcode byte, mask bool, maskBytes [4]byte, paylo
 	return FrameHeader{final, opcode, false, false, false, mask, maskBytes, payloadLength}
 }
 
-func (fh FrameHeader) toByteSlice() (result []byte) {
-	var byte1 byte
-	var byte2 byte
+// Final reports if this is the final frame of a message
+func (fh FrameHeader) Final() bool {
+	return fh.final
+}
 
-	if fh.final {
-		byte1 = 1 << 7
-	}
+// Opcode returns the opcode of the frame
+func (fh FrameHeader) Opcode() byte {
+	return fh.opcode
+}
 
+// RSV returns the reserved bits of the frame as a combination of RSV1, RSV2 and RSV3
+func (fh FrameHeader) RSV() (rsv byte) {
 	if fh.rsv1 {
-		byte1 |= 1 << 6
+		rsv |= RSV1
 	}
 
 	if fh.rsv2 {
-		byte1 |= 1 << 5
+		rsv |= RSV2
 	}
 
 	if fh.rsv3 {
-		byte1 |= 1 << 4
+		rsv |= RSV3
+	}
+
+	return rsv
+}
+
+// SetRSV sets the reserved bits of the frame, rsv is a combination of RSV1, RSV2 and RSV3
+func (fh *FrameHeader) SetRSV(rsv byte) {
+	fh.rsv1 = rsv&RSV1 != 0
+	fh.rsv2 = rsv&RSV2 != 0
+	fh.rsv3 = rsv&RSV3 != 0
+}
+
+// PayloadLength returns the length of the frame's payload in bytes
+func (fh FrameHeader) PayloadLength() int64 {
+	return fh.payloadLength
+}
+
+// Largest payload lengths that fit the 7 bit and 16 bit length encodings
+const (
+	maxShortPayloadLength  = 125
+	maxMediumPayloadLength = 0xffff
+)
+
+// ReadFrameHeader reads and decodes a frame header from r, extended payload
+// lengths are in network byte order and must use the minimal encoding
+func ReadFrameHeader(r io.Reader) (fh FrameHeader, err error) {
+	var p [8]byte
+
+	return readFrameHeader(r, p[:])
+}
+
+// readFrameHeader decodes a frame header using p as scratch space of at
+// least 8 bytes, so reading a header from a connection allocates nothing
+func readFrameHeader(r io.Reader, p []byte) (fh FrameHeader, err error) {
+	// 1 : Control bits
+
+	if _, err = io.ReadFull(r, p[:2]); err != nil {
+		return fh, err
 	}
 
-	byte1 |= fh.opcode
+	fh.final = p[0]&finalBitMask != 0
+	fh.rsv1 = p[0]&rsv1BitMask != 0
+	fh.rsv2 = p[0]&rsv2BitMask != 0
+	fh.rsv3 = p[0]&rsv3BitMask != 0
+	fh.opcode = p[0] & opCodeMask
+	fh.mask = p[1]&maskMask != 0
+
+	// 2 : Payload length
+
+	fh.payloadLength = int64(p[1] & payloadLengthMask)
+
+	switch fh.payloadLength {
+	case 126:
+		if _, err = io.ReadFull(r, p[:2]); err != nil {
+			return fh, err
+		}
+
+		fh.payloadLength = int64(binary.BigEndian.Uint16(p[:2]))
+
+		if fh.payloadLength <= maxShortPayloadLength {
+			return fh, ErrBadFrame
+		}
+	case 127:
+		if _, err = io.ReadFull(r, p[:8]); err != nil {
+			return fh, err
+		}
+
+		// The most significant bit must be 0, see rfc6455#section-5.2
+		if p[0]&0x80 != 0 {
+			return fh, ErrBadFrame
+		}
+
+		fh.payloadLength = int64(binary.BigEndian.Uint64(p[:8]))
+
+		if fh.payloadLength <= maxMediumPayloadLength {
+			return fh, ErrBadFrame
+		}
+	}
+
+	// 3 : Masking key
 
 	if fh.mask {
-		byte2 = 1 << 7
+		if _, err = io.ReadFull(r, p[:4]); err != nil {
+			return fh, err
+		}
+
+		copy(fh.maskBytes[:], p[:4])
 	}
 
-	var plBytes []byte
+	return fh, nil
+}
+
+// AppendFrameHeader appends the encoded frame header to b, the payload length
+// uses the minimal encoding in network byte order
+func AppendFrameHeader(b []byte, fh FrameHeader) []byte {
+	var byte1 byte
+	var byte2 byte
+
+	if fh.final {
+		byte1 = finalBitMask
+	}
+
+	byte1 |= fh.RSV()
+	byte1 |= fh.opcode & opCodeMask
+
+	if fh.mask {
+		byte2 = maskMask
+	}
 
 	switch {
-	case fh.payloadLength > 65537:
-		byte2 |= 127
-
-		// We have to transform the payloadLength to a byte slice to append it
-		// to the frame header result. If the payloadLength is greater than 65537
-		// this means we have to store this length in a uint64
-		plBytes = make([]byte, 8)
-		binary.LittleEndian.PutUint64(plBytes, uint64(fh.payloadLength))
-	case fh.payloadLength > 125:
-		byte2 |= 126
-
-		// We have to transform the payloadLength to a byte slice to append it
-		// to the frame header result. If the payload length is greater than 125
-		// but smaller than 65537 this means we have to store this length in a
-		// uint16
-		plBytes = make([]byte, 2)
-		binary.LittleEndian.PutUint16(plBytes, uint16(fh.payloadLength))
+	case fh.payloadLength > maxMediumPayloadLength:
+		b = append(b, byte1, byte2|127)
+		b = append(b, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(b[len(b)-8:], uint64(fh.payloadLength))
+	case fh.payloadLength > maxShortPayloadLength:
+		b = append(b, byte1, byte2|126)
+		b = append(b, make([]byte, 2)...)
+		binary.BigEndian.PutUint16(b[len(b)-2:], uint16(fh.payloadLength))
 	default:
-		byte2 |= byte(fh.payloadLength)
+		b = append(b, byte1, byte2|byte(fh.payloadLength))
 	}
 
-	result = append(result, byte1, byte2)
-	result = append(result, plBytes...)
-
 	if fh.mask {
-		result = append(result, fh.maskBytes[0], fh.maskBytes[1], fh.maskBytes[2], fh.maskBytes[3])
+		b = append(b, fh.maskBytes[:]...)
 	}
 
-	return result
+	return b
 }
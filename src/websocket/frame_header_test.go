@@ -2,13 +2,14 @@ package websocket
 
 import (
 	"bytes"
+	"io"
 	"testing"
 )
 
 func TestFrameHeader(t *testing.T) {
 	fh := NewFrameHeader(false, 0x5, true, [4]byte{0xf, 0xf, 0xf, 0xf}, 100)
 
-	bs := fh.toByteSlice()
+	bs := AppendFrameHeader(nil, fh)
 
 	if len(bs) != 6 {
 		t.Errorf("Expected bs to have length 6 but got %d", len(bs))
@@ -42,7 +43,7 @@ func TestFrameHeader(t *testing.T) {
 func TestFrameHeaderTwo(t *testing.T) {
 	fh := NewFrameHeader(true, 0x9, false, [4]byte{0xf, 0xf, 0xf, 0xf}, 100)
 
-	bs := fh.toByteSlice()
+	bs := AppendFrameHeader(nil, fh)
 
 	if len(bs) != 2 {
 		t.Errorf("Expected bs to have length 2 but got %d", len(bs))
@@ -71,7 +72,7 @@ func TestFrameHeaderTwo(t *testing.T) {
 
 func TestFrameHeaderPayloadLengthTwo(t *testing.T) {
 	fh := NewFrameHeader(false, 0x5, true, [4]byte{0xf, 0xf, 0xf, 0xf}, 126)
-	bs := fh.toByteSlice()
+	bs := AppendFrameHeader(nil, fh)
 
 	if len(bs) != 8 {
 		t.Errorf("Expected bs to have length 8 but got %d", len(bs))
@@ -80,7 +81,7 @@ func TestFrameHeaderPayloadLengthTwo(t *testing.T) {
 
 func TestFrameHeaderPayloadLengthThree(t *testing.T) {
 	fh := NewFrameHeader(false, 0x5, true, [4]byte{0xf, 0xf, 0xf, 0xf}, 127)
-	bs := fh.toByteSlice()
+	bs := AppendFrameHeader(nil, fh)
 
 	if len(bs) != 8 {
 		t.Errorf("Expected bs to have length 8 but got %d", len(bs))
@@ -89,9 +90,75 @@ func TestFrameHeaderPayloadLengthThree(t *testing.T) {
 
 func TestFrameHeader4(t *testing.T) {
 	fh := NewFrameHeader(false, 0x5, true, [4]byte{0xf, 0xf, 0xf, 0xf}, 65538)
-	bs := fh.toByteSlice()
+	bs := AppendFrameHeader(nil, fh)
 
 	if len(bs) != 14 {
 		t.Errorf("Expected bs to have length 14 but got %d", len(bs))
 	}
 }
+
+func TestAppendFrameHeaderLengths(t *testing.T) {
+	cases := []struct {
+		payloadLength int64
+		expected      []byte
+	}{
+		{125, []byte{0x82, 125}},
+		{126, []byte{0x82, 126, 0x00, 0x7e}},
+		{65535, []byte{0x82, 126, 0xff, 0xff}},
+		{65536, []byte{0x82, 127, 0, 0, 0, 0, 0, 0x01, 0x00, 0x00}},
+		{1 << 40, []byte{0x82, 127, 0, 0, 0x01, 0, 0, 0, 0, 0}},
+	}
+
+	for _, c := range cases {
+		fh := NewFrameHeader(true, BinaryMessage, false, [4]byte{}, c.payloadLength)
+
+		if bs := AppendFrameHeader(nil, fh); !bytes.Equal(bs, c.expected) {
+			t.Errorf("Expected header for length %d to equal %v but got %v", c.payloadLength, c.expected, bs)
+		}
+	}
+}
+
+func TestReadFrameHeaderRoundTrip(t *testing.T) {
+	for _, payloadLength := range []int64{0, 125, 126, 65535, 65536, 1 << 40} {
+		fh := NewFrameHeader(true, TextMessage, true, [4]byte{1, 2, 3, 4}, payloadLength)
+		fh.SetRSV(RSV1 | RSV3)
+
+		// Append to a non empty slice and check the prefix is kept
+		bs := AppendFrameHeader([]byte{0xaa}, fh)
+
+		if bs[0] != 0xaa {
+			t.Errorf("Expected AppendFrameHeader to keep the existing bytes")
+		}
+
+		decoded, err := ReadFrameHeader(bytes.NewReader(bs[1:]))
+
+		if err != nil {
+			t.Errorf("Unexpected error for length %d: %s", payloadLength, err)
+		}
+
+		if decoded != fh {
+			t.Errorf("Expected %+v but got %+v", fh, decoded)
+		}
+	}
+}
+
+func TestReadFrameHeaderErrors(t *testing.T) {
+	cases := []struct {
+		name     string
+		header   []byte
+		expected error
+	}{
+		{"non minimal 16 bit length", []byte{0x82, 126, 0x00, 0x7d}, ErrBadFrame},
+		{"non minimal 64 bit length", []byte{0x82, 127, 0, 0, 0, 0, 0, 0, 0xff, 0xff}, ErrBadFrame},
+		{"most significant bit set", []byte{0x82, 127, 0x80, 0, 0, 0, 0, 0, 0, 0}, ErrBadFrame},
+		{"truncated length", []byte{0x82, 126, 0x01}, io.ErrUnexpectedEOF},
+		{"truncated mask", []byte{0x82, 0x81, 1, 2}, io.ErrUnexpectedEOF},
+		{"empty", []byte{}, io.EOF},
+	}
+
+	for _, c := range cases {
+		if _, err := ReadFrameHeader(bytes.NewReader(c.header)); err != c.expected {
+			t.Errorf("%s: expected %v but got %v", c.name, c.expected, err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultPingHandler(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	go writeTestFrame(src, true, true, PingMessage, []byte("ping"))
+	go ws.Receive()
+
+	frames, err := readRawFrames(src)
+
+	if assert.Nil(t, err) {
+		assert.Equal(t, byte(PongMessage), frames[0].header.Opcode())
+		assert.Equal(t, "ping", string(frames[0].payload))
+	}
+}
+
+func TestPingHandler(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	ws.SetPingHandler(func(appData []byte) error {
+		return ws.Send(PongMessage, append([]byte("re: "), appData...))
+	})
+
+	go writeTestFrame(src, true, true, PingMessage, []byte("ping"))
+	go ws.Receive()
+
+	frames, err := readRawFrames(src)
+
+	if assert.Nil(t, err) {
+		assert.Equal(t, byte(PongMessage), frames[0].header.Opcode())
+		assert.Equal(t, "re: ping", string(frames[0].payload))
+	}
+}
+
+func TestPongHandler(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	var pongs []string
+
+	ws.SetPongHandler(func(appData []byte) error {
+		pongs = append(pongs, string(appData))
+		return nil
+	})
+
+	go func() {
+		writeTestFrame(src, true, true, PongMessage, []byte("1"))
+		writeTestFrame(src, true, true, PongMessage, []byte("2"))
+		writeTestFrame(src, true, true, TextMessage, []byte("hello"))
+	}()
+
+	_, message, err := ws.Receive()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(message))
+	assert.Equal(t, []string{"1", "2"}, pongs)
+
+	// A failing handler fails the read
+	handlerErr := errors.New("bad pong")
+
+	ws.SetPongHandler(func([]byte) error { return handlerErr })
+
+	go writeTestFrame(src, true, true, PongMessage, nil)
+
+	_, _, err = ws.Receive()
+
+	assert.Equal(t, handlerErr, err)
+}
+
+func TestCloseHandler(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	var code int
+	var text string
+
+	ws.SetCloseHandler(func(c int, t string) error {
+		code, text = c, t
+		return nil
+	})
+
+	go writeTestFrame(src, true, true, CloseMessage, FormatCloseMessage(CloseStatusGoingAway, "restart"))
+
+	status := make(chan int, 1)
+
+	go func() {
+		status <- closeStatus(t, src)
+	}()
+
+	_, _, err = ws.Receive()
+
+	assert.Equal(t, &CloseError{Code: CloseStatusGoingAway, Text: "restart"}, err)
+	assert.Equal(t, CloseStatusGoingAway, code)
+	assert.Equal(t, "restart", text)
+
+	// The closing handshake still echoes the status code
+	assert.Equal(t, CloseStatusGoingAway, <-status)
+}
+
+func TestCloseHandlerSendsClose(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	ws.SetCloseHandler(func(code int, text string) error {
+		return ws.Send(CloseMessage, FormatCloseMessage(CloseStatusNormal, "bye"))
+	})
+
+	go writeTestFrame(src, true, true, CloseMessage, FormatCloseMessage(CloseStatusGoingAway, ""))
+
+	echo := make(chan []rawFrame, 1)
+
+	go func() {
+		frames, _ := readRawFrames(src)
+		echo <- frames
+	}()
+
+	_, _, err = ws.Receive()
+
+	assert.True(t, IsCloseError(err, CloseStatusGoingAway))
+
+	// Only the handler's close frame is sent
+	if frames := <-echo; assert.Equal(t, 1, len(frames)) {
+		assert.Equal(t, FormatCloseMessage(CloseStatusNormal, "bye"), frames[0].payload)
+	}
+}
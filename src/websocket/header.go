@@ -0,0 +1,71 @@
+package websocket
+
+import (
+	"net/http"
+	"strings"
+)
+
+// headerTokens returns the comma separated tokens of all header values for
+// name, e.g. `Sec-WebSocket-Protocol: chat, superchat`
+func headerTokens(header http.Header, name string) (tokens []string) {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, token := range strings.Split(value, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+
+	return tokens
+}
+
+// headerContainsToken reports if the header name contains token, compared
+// case insensitively as required for e.g. `Connection: keep-alive, Upgrade`
+func headerContainsToken(header http.Header, name string, token string) bool {
+	for _, t := range headerTokens(header, name) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsToken(tokens []string, token string) bool {
+	for _, t := range tokens {
+		if t == token {
+			return true
+		}
+	}
+
+	return false
+}
+
+// extension is one element of a Sec-WebSocket-Extensions header, e.g.
+// `permessage-deflate; client_max_window_bits=10`
+type extension struct {
+	name   string
+	params map[string]string
+}
+
+// parseExtensions parses all Sec-WebSocket-Extensions header values in order
+func parseExtensions(header http.Header) (extensions []extension) {
+	for _, token := range headerTokens(header, "Sec-WebSocket-Extensions") {
+		parts := strings.Split(token, ";")
+		ext := extension{strings.TrimSpace(parts[0]), map[string]string{}}
+
+		for _, param := range parts[1:] {
+			name, value := param, ""
+
+			if i := strings.Index(param, "="); i >= 0 {
+				name, value = param[:i], strings.Trim(strings.TrimSpace(param[i+1:]), `"`)
+			}
+
+			ext.params[strings.TrimSpace(name)] = value
+		}
+
+		extensions = append(extensions, ext)
+	}
+
+	return extensions
+}
@@ -0,0 +1,115 @@
+package websocket
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// keepAlive pings the peer every interval, the peer is considered gone when
+// no pong arrives within interval + pongWait
+type keepAlive struct {
+	interval time.Duration
+	pongWait time.Duration
+
+	// lastPong is the time of the last pong in unix nanoseconds
+	lastPong int64
+
+	// deadline is the read deadline set by keepalive in unix nanoseconds, it
+	// is zero once the application sets its own read deadline
+	deadline int64
+
+	stop chan struct{}
+}
+
+// SetKeepAlive sends a ping every pingInterval and expects a pong within
+// pongWait, otherwise Receive returns a *CloseError with status 1006 and the
+// network connection is closed. Pongs are handled by the reading goroutine so
+// the connection must be read from, call SetKeepAlive before reading starts.
+// A zero pingInterval disables keepalive.
+func (conn *Conn) SetKeepAlive(pingInterval, pongWait time.Duration) {
+	if conn.keepAlive != nil {
+		close(conn.keepAlive.stop)
+		conn.keepAlive = nil
+	}
+
+	if pingInterval <= 0 {
+		conn.SetReadDeadline(time.Time{})
+		return
+	}
+
+	ka := &keepAlive{
+		interval: pingInterval,
+		pongWait: pongWait,
+		lastPong: time.Now().UnixNano(),
+		stop:     make(chan struct{}),
+	}
+
+	conn.keepAlive = ka
+	ka.setDeadline(conn, time.Now())
+
+	go ka.ping(conn)
+}
+
+// LastPong returns when the last pong was received since keepalive was
+// enabled, or the zero time when keepalive is disabled
+func (conn *Conn) LastPong() time.Time {
+	if conn.keepAlive == nil {
+		return time.Time{}
+	}
+
+	return time.Unix(0, atomic.LoadInt64(&conn.keepAlive.lastPong))
+}
+
+func (ka *keepAlive) timeout() time.Duration {
+	return ka.interval + ka.pongWait
+}
+
+// ping sends pings until keepalive is stopped or the connection is closed
+func (ka *keepAlive) ping(conn *Conn) {
+	ticker := time.NewTicker(ka.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ka.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if err := conn.Send(PingMessage, nil); err != nil {
+			return
+		}
+	}
+}
+
+// pong records the pong and extends the read deadline
+func (ka *keepAlive) pong(conn *Conn) {
+	now := time.Now()
+
+	atomic.StoreInt64(&ka.lastPong, now.UnixNano())
+	ka.setDeadline(conn, now)
+}
+
+// setDeadline sets and records the read deadline for a pong expected after now
+func (ka *keepAlive) setDeadline(conn *Conn, now time.Time) {
+	deadline := now.Add(ka.timeout())
+
+	atomic.StoreInt64(&ka.deadline, deadline.UnixNano())
+	conn.setReadDeadline(deadline)
+}
+
+// isKeepAliveTimeout reports if err is the expiry of the read deadline set by
+// keepalive, deadlines set by the application are not reported
+func isKeepAliveTimeout(conn *Conn, err error) bool {
+	netErr, ok := err.(net.Error)
+	ka := conn.keepAlive
+
+	if !ok || !netErr.Timeout() || ka == nil {
+		return false
+	}
+
+	deadline := atomic.LoadInt64(&ka.deadline)
+
+	return deadline != 0 && time.Now().UnixNano() >= deadline
+}
@@ -0,0 +1,121 @@
+package websocket
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeepAlivePong(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	// The peer answers every ping
+	pings := make(chan struct{}, 100)
+
+	go func() {
+		for {
+			frames, err := readRawFrames(src)
+
+			if err != nil {
+				return
+			}
+
+			if frames[0].header.Opcode() == PingMessage {
+				pings <- struct{}{}
+				writeTestFrame(src, true, true, PongMessage, frames[0].payload)
+			}
+		}
+	}()
+
+	start := time.Now()
+
+	ws.SetKeepAlive(20*time.Millisecond, 20*time.Millisecond)
+	defer ws.SetKeepAlive(0, 0)
+
+	received := make(chan error, 1)
+
+	go func() {
+		_, _, err := ws.Receive()
+		received <- err
+	}()
+
+	select {
+	case err := <-received:
+		t.Fatal("Expected pongs to keep the connection alive", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	assert.True(t, len(pings) >= 3, "Expected periodic pings", len(pings))
+	assert.True(t, ws.LastPong().After(start), "Expected the last pong to be tracked")
+
+	src.Close()
+	<-received
+}
+
+func TestKeepAliveTimeout(t *testing.T) {
+	src, ws, err := wsPipe(nil)
+
+	assert.Nil(t, err)
+
+	// The peer reads the pings but never answers
+	go io.Copy(ioutil.Discard, src)
+
+	ws.SetKeepAlive(20*time.Millisecond, 20*time.Millisecond)
+
+	start := time.Now()
+
+	_, _, err = ws.Receive()
+
+	assert.Equal(t, &CloseError{Code: CloseStatusAbnormalClosure, Text: "keepalive timeout"}, err)
+	assert.True(t, time.Since(start) < time.Second, "Expected the silent peer to be detected")
+	assert.Equal(t, ErrCloseSent, ws.Send(TextMessage, []byte("late")))
+
+	// Reads keep reporting the abnormal closure
+	_, _, err = ws.Receive()
+
+	assert.True(t, IsCloseError(err, CloseStatusAbnormalClosure))
+}
+
+func TestKeepAliveDisabled(t *testing.T) {
+	_, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+	assert.True(t, ws.LastPong().IsZero())
+
+	ws.SetKeepAlive(time.Hour, time.Second)
+
+	assert.False(t, ws.LastPong().IsZero())
+
+	ws.SetKeepAlive(0, 0)
+
+	assert.True(t, ws.LastPong().IsZero())
+}
+
+func TestKeepAliveApplicationDeadline(t *testing.T) {
+	src, ws, err := wsPipe(nil)
+
+	assert.Nil(t, err)
+
+	go io.Copy(ioutil.Discard, src)
+
+	ws.SetKeepAlive(time.Hour, time.Second)
+	defer ws.SetKeepAlive(0, 0)
+
+	// The application's own read deadline is not a keepalive timeout
+	ws.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+
+	_, _, err = ws.Receive()
+
+	netErr, ok := err.(net.Error)
+
+	assert.True(t, ok && netErr.Timeout(), "Expected the read deadline to expire", err)
+	assert.False(t, IsCloseError(err, CloseStatusAbnormalClosure))
+	assert.Nil(t, ws.Send(TextMessage, nil), "Expected the connection to stay open")
+}
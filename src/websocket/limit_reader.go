@@ -0,0 +1,32 @@
+package websocket
+
+import (
+	"io"
+)
+
+// limitReader fails the connection when the message read from r is larger
+// than remaining bytes
+type limitReader struct {
+	fspec     *FrameSpecHandler
+	r         io.Reader
+	remaining int64
+}
+
+func (r *limitReader) Read(b []byte) (n int, err error) {
+	// Read one byte past the limit to detect a message that exceeds it
+	if int64(len(b)) > r.remaining+1 {
+		b = b[:r.remaining+1]
+	}
+
+	n, err = r.r.Read(b)
+
+	if int64(n) > r.remaining {
+		conn := r.fspec.conn
+
+		return int(r.remaining), r.fspec.failConnection(CloseStatusTooBigData, "message too big", &ReadLimitError{conn.readLimit})
+	}
+
+	r.remaining -= int64(n)
+
+	return n, err
+}
@@ -0,0 +1,130 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// closeStatus reads the next frame from r and returns its close status code
+func closeStatus(t *testing.T, r io.Reader) int {
+	frames, err := readRawFrames(r)
+
+	if !assert.Nil(t, err) || !assert.Equal(t, byte(CloseMessage), frames[0].header.Opcode()) {
+		return 0
+	}
+
+	return int(binary.BigEndian.Uint16(frames[0].payload))
+}
+
+func TestReadLimitFrame(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	ws.SetReadLimit(10)
+
+	// The header alone must fail the read, the payload is never sent
+
+	go src.Write(AppendFrameHeader(nil, NewFrameHeader(true, BinaryMessage, true, testMaskKey, 1<<40)))
+
+	status := make(chan int, 1)
+
+	go func() {
+		status <- closeStatus(t, src)
+	}()
+
+	_, _, err = ws.Receive()
+
+	assert.Equal(t, &ReadLimitError{10}, err)
+	assert.Equal(t, CloseStatusTooBigData, <-status)
+}
+
+func TestReadLimitFragmentedMessage(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	ws.SetReadLimit(10)
+
+	go func() {
+		writeTestFrame(src, true, false, TextMessage, []byte("hello "))
+		writeTestFrame(src, true, true, ContinuationFrame, []byte("world"))
+	}()
+
+	status := make(chan int, 1)
+
+	go func() {
+		status <- closeStatus(t, src)
+	}()
+
+	_, _, err = ws.Receive()
+
+	_, ok := err.(*ReadLimitError)
+
+	assert.True(t, ok, "Expected a *ReadLimitError", err)
+	assert.Equal(t, CloseStatusTooBigData, <-status)
+}
+
+func TestReadLimitExact(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	ws.SetReadLimit(10)
+
+	go func() {
+		writeTestFrame(src, true, false, TextMessage, []byte("hello"))
+		writeTestFrame(src, true, true, ContinuationFrame, []byte("world"))
+		writeTestFrame(src, true, true, TextMessage, []byte("0123456789"))
+	}()
+
+	for _, expected := range []string{"helloworld", "0123456789"} {
+		opcode, message, err := ws.Receive()
+
+		assert.Nil(t, err)
+		assert.Equal(t, byte(TextMessage), opcode)
+		assert.Equal(t, expected, string(message))
+	}
+}
+
+func TestReadLimitCompressedMessage(t *testing.T) {
+	src, ws, err := compressedPipe()
+
+	assert.Nil(t, err)
+
+	ws.SetReadLimit(100)
+
+	// A small compressed payload that inflates past the limit
+
+	payload, _ := compress(newCompression(false, deflateParams{}), bytes.Repeat([]byte("a"), 1000))
+
+	assert.True(t, len(payload) < 100)
+
+	fh := NewFrameHeader(true, TextMessage, true, testMaskKey, int64(len(payload)))
+	fh.SetRSV(RSV1)
+
+	mask(0, testMaskKey, payload)
+
+	go func() {
+		src.Write(AppendFrameHeader(nil, fh))
+		src.Write(payload)
+	}()
+
+	status := make(chan int, 1)
+
+	go func() {
+		status <- closeStatus(t, src)
+	}()
+
+	_, _, err = ws.Receive()
+
+	assert.Equal(t, &ReadLimitError{100}, err)
+	assert.Equal(t, CloseStatusTooBigData, <-status)
+	assert.True(t, strings.Contains(err.Error(), "100"))
+}
@@ -18,17 +18,15 @@ func NewMaskedReader(reader io.Reader, mask [4]byte) *MaskedReader {
 	return &MaskedReader{reader, mask, 0}
 }
 
-func unmask(offset int, mask [4]byte, bytes []byte) {
-	for i, b := range bytes {
-		m := mask[(offset+i)%4]
-		bytes[i] = b ^ m
-	}
+// unmask reverts mask, XOR with the same key restores the bytes
+func unmask(offset int, key [4]byte, bytes []byte) {
+	mask(offset, key, bytes)
 }
 
 func (r *MaskedReader) Read(b []byte) (n int, err error) {
 	n, err = r.rd.Read(b)
 
-	unmask(r.offset, r.mask, b)
+	unmask(r.offset, r.mask, b[:n])
 
 	r.offset += n
 
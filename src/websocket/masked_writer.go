@@ -1,7 +1,10 @@
 package websocket
 
 import (
+	"crypto/rand"
+	"encoding/binary"
 	"io"
+	"sync"
 )
 
 // MaskedWriter write to a io.Writer using a 4 byte mask
@@ -16,20 +19,92 @@ func NewMaskedWriter(writer io.Writer, mask [4]byte) *MaskedWriter {
 	return &MaskedWriter{writer, mask, 0}
 }
 
-func mask(offset int, mask [4]byte, bytes []byte) {
-	for i, b := range bytes {
-		m := mask[(offset+i)%4]
-		bytes[i] = m ^ b
+// maskKeySource hands out masking keys from a cryptographically strong
+// source, clients use a new key for every frame, see rfc6455#section-5.3. The
+// keys are read from crypto/rand in batches so a frame allocates nothing.
+type maskKeySource struct {
+	keys [256]byte
+	next int
+}
+
+// key returns the next unused masking key
+func (s *maskKeySource) key() (key [4]byte, err error) {
+	if s.next == 0 {
+		if _, err = io.ReadFull(rand.Reader, s.keys[:]); err != nil {
+			return key, err
+		}
 	}
+
+	copy(key[:], s.keys[s.next:])
+	s.next = (s.next + len(key)) % len(s.keys)
+
+	return key, nil
 }
 
-// Writer mask input bytes and write to the underlying writer
+// mask XORs bytes in place with the key, offset is the position of bytes[0]
+// in the payload. The bytes are masked a word at a time once the key is
+// aligned with the payload position.
+func mask(offset int, key [4]byte, bytes []byte) {
+	i := 0
+
+	// 1 : Mask the head byte by byte until the next key byte is key[0]
+
+	for ; i < len(bytes) && (offset+i)%4 != 0; i++ {
+		bytes[i] ^= key[(offset+i)%4]
+	}
+
+	// 2 : Mask 8 bytes at a time with the key repeated twice
+
+	if len(bytes)-i >= 8 {
+		word := uint64(binary.LittleEndian.Uint32(key[:]))
+		word |= word << 32
+
+		for ; len(bytes)-i >= 8; i += 8 {
+			binary.LittleEndian.PutUint64(bytes[i:], binary.LittleEndian.Uint64(bytes[i:])^word)
+		}
+	}
+
+	// 3 : Mask the remaining tail byte by byte
+
+	for ; i < len(bytes); i++ {
+		bytes[i] ^= key[(offset+i)%4]
+	}
+}
+
+// maskBufferSize is the size of the scratch buffers bytes are masked in
+const maskBufferSize = 4096
+
+// maskBufferPool holds the scratch buffers of MaskedWriter and of connections
+// without a write buffer pool
+var maskBufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, maskBufferSize)
+		return &b
+	},
+}
+
+// Write masks b into a scratch buffer and writes it to the underlying writer,
+// b itself is left unchanged
 func (wr *MaskedWriter) Write(b []byte) (n int, err error) {
-	mask(wr.offset, wr.mask, b)
+	buf := maskBufferPool.Get().(*[]byte)
+	defer maskBufferPool.Put(buf)
+
+	for len(b) > 0 {
+		chunk := (*buf)[:copy(*buf, b)]
+		mask(wr.offset, wr.mask, chunk)
+
+		m, err := wr.writer.Write(chunk)
 
-	n, err = wr.writer.Write(b)
+		// Only the written bytes advance the key position
+		wr.offset += m
+		n += m
 
-	wr.offset += n
+		if err != nil {
+			return n, err
+		}
+
+		b = b[m:]
+	}
 
-	return n, err
+	return n, nil
 }
@@ -3,6 +3,7 @@ package websocket
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"testing"
 )
 
@@ -54,3 +55,175 @@ func TestWriterMasked(t *testing.T) {
 		}
 	}
 }
+
+func TestWriterLeavesInput(t *testing.T) {
+	key := [4]byte{0x1, 0x2, 0x3, 0x4}
+
+	// Larger than a scratch buffer
+	input := bytes.Repeat([]byte("0123456789"), 1000)
+	original := append([]byte(nil), input...)
+
+	wd := bytes.NewBuffer(nil)
+	n, err := NewMaskedWriter(wd, key).Write(input)
+
+	if err != nil || n != len(input) {
+		t.Error("Expected to write the whole input", n, err)
+	}
+
+	if !bytes.Equal(original, input) {
+		t.Error("Expected the input to be left unchanged")
+	}
+
+	expected := append([]byte(nil), input...)
+	maskBytewise(0, key, expected)
+
+	if !bytes.Equal(expected, wd.Bytes()) {
+		t.Error("Expected the output to be masked")
+	}
+}
+
+// shortWriter accepts limit bytes and fails after that
+type shortWriter struct {
+	bytes.Buffer
+	limit int
+}
+
+func (w *shortWriter) Write(b []byte) (int, error) {
+	if len(b) > w.limit {
+		n, _ := w.Buffer.Write(b[:w.limit])
+		w.limit = 0
+
+		return n, errors.New("short write")
+	}
+
+	w.limit -= len(b)
+
+	return w.Buffer.Write(b)
+}
+
+func TestWriterShortWrite(t *testing.T) {
+	key := [4]byte{0x1, 0x2, 0x3, 0x4}
+	input := []byte("hello world")
+
+	wd := &shortWriter{limit: 3}
+	mwd := NewMaskedWriter(wd, key)
+
+	n, err := mwd.Write(input)
+
+	if err == nil || n != 3 {
+		t.Error("Expected a short write of 3 bytes", n, err)
+	}
+
+	// Resuming after the written bytes continues with the right key byte
+	wd.limit = len(input)
+
+	if _, err := mwd.Write(input[n:]); err != nil {
+		t.Error(err)
+	}
+
+	expected := append([]byte(nil), input...)
+	maskBytewise(0, key, expected)
+
+	if !bytes.Equal(expected, wd.Bytes()) {
+		t.Error("Expected the key position to follow the written bytes")
+	}
+}
+
+// maskBytewise is the byte at a time masking mask is checked and benchmarked
+// against
+func maskBytewise(offset int, key [4]byte, bytes []byte) {
+	for i, b := range bytes {
+		bytes[i] = b ^ key[(offset+i)%4]
+	}
+}
+
+func TestMaskMatchesBytewise(t *testing.T) {
+	key := [4]byte{0x12, 0x34, 0x56, 0x78}
+
+	for length := 0; length < 40; length++ {
+		for offset := 0; offset < 8; offset++ {
+			expected := make([]byte, length)
+
+			for i := range expected {
+				expected[i] = byte(i * 7)
+			}
+
+			actual := append([]byte(nil), expected...)
+
+			maskBytewise(offset, key, expected)
+			mask(offset, key, actual)
+
+			if !bytes.Equal(expected, actual) {
+				t.Error("Expected mask to match the bytewise mask", length, offset)
+			}
+
+			unmask(offset, key, actual)
+			maskBytewise(offset, key, expected)
+
+			if !bytes.Equal(expected, actual) {
+				t.Error("Expected unmask to restore the input", length, offset)
+			}
+		}
+	}
+}
+
+func TestWriterMaskedChunks(t *testing.T) {
+	key := [4]byte{0x1, 0x2, 0x3, 0x4}
+	input := bytes.Repeat([]byte("websocket"), 20)
+
+	expected := append([]byte(nil), input...)
+	maskBytewise(0, key, expected)
+
+	// Writes of odd sizes keep the key position between writes
+	wd := bytes.NewBuffer(nil)
+	mwd := NewMaskedWriter(wd, key)
+
+	for rest := append([]byte(nil), input...); len(rest) > 0; {
+		n := 13
+
+		if n > len(rest) {
+			n = len(rest)
+		}
+
+		mwd.Write(rest[:n])
+		rest = rest[n:]
+	}
+
+	if !bytes.Equal(expected, wd.Bytes()) {
+		t.Error("Expected chunked writes to mask like a single write")
+	}
+}
+
+func benchmarkMask(b *testing.B, maskFunc func(int, [4]byte, []byte), size int) {
+	key := [4]byte{0x12, 0x34, 0x56, 0x78}
+	payload := make([]byte, size)
+
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		maskFunc(1, key, payload)
+	}
+}
+
+func BenchmarkMask(b *testing.B) {
+	sizes := []struct {
+		name string
+		size int
+	}{
+		{"1KiB", 1 << 10},
+		{"64KiB", 64 << 10},
+		{"1MiB", 1 << 20},
+		{"16MiB", 16 << 20},
+	}
+
+	for _, s := range sizes {
+		b.Run("word/"+s.name, func(b *testing.B) {
+			benchmarkMask(b, mask, s.size)
+		})
+
+		b.Run("bytewise/"+s.name, func(b *testing.B) {
+			benchmarkMask(b, maskBytewise, s.size)
+		})
+	}
+}
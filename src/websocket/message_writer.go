@@ -0,0 +1,87 @@
+package websocket
+
+import (
+	"errors"
+)
+
+const defaultWriteFrameSize = 4096
+
+var (
+	errBadWriteOpcode       = errors.New("websocket: NextWriter requires a text or binary opcode")
+	errControlFrameTooLarge = errors.New("websocket: control frame payload exceeds 125 bytes")
+	errWriteClosed          = errors.New("websocket: write to closed message writer")
+	errInvalidFrameSize     = errors.New("websocket: invalid write frame size")
+)
+
+// messageWriter writes a message as a first frame followed by continuation
+// frames of at most frame size bytes, the final frame is sent on Close. Other
+// messages wait until Close, control frames may be sent between the frames.
+type messageWriter struct {
+	fspec *FrameSpecHandler
+
+	// header is the header of the next frame to send
+	header FrameHeader
+
+	buf    []byte
+	closed bool
+	err    error
+}
+
+// Write buffers b and sends a frame every time the buffer is full and more
+// data follows, so the final frame is never empty unless the message is
+func (w *messageWriter) Write(b []byte) (n int, err error) {
+	if w.closed {
+		return 0, errWriteClosed
+	}
+
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	for len(b) > 0 {
+		if len(w.buf) == cap(w.buf) {
+			if w.err = w.flushFrame(false); w.err != nil {
+				return n, w.err
+			}
+		}
+
+		m := copy(w.buf[len(w.buf):cap(w.buf)], b)
+		w.buf = w.buf[:len(w.buf)+m]
+
+		n += m
+		b = b[m:]
+	}
+
+	return n, nil
+}
+
+func (w *messageWriter) flushFrame(final bool) error {
+	w.header.final = final
+	w.header.payloadLength = int64(len(w.buf))
+
+	if err := w.fspec.writeFrame(w.header, w.buf); err != nil {
+		return err
+	}
+
+	// Only the first frame carries the opcode and the extensions' RSV bits
+	w.header = NewFrameHeader(false, ContinuationFrame, w.header.mask, w.header.maskBytes, 0)
+	w.buf = w.buf[:0]
+
+	return nil
+}
+
+// Close sends the final frame and lets the next message be written
+func (w *messageWriter) Close() error {
+	if w.closed {
+		return errWriteClosed
+	}
+
+	w.closed = true
+	defer w.fspec.messageMu.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+
+	return w.flushFrame(true)
+}
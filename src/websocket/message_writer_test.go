@@ -0,0 +1,258 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextWriterFragments(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+	assert.Nil(t, ws.SetWriteFrameSize(4))
+
+	go func() {
+		w, _ := ws.NextWriter(TextMessage)
+
+		io.WriteString(w, "hello")
+		io.WriteString(w, " world")
+		w.Close()
+	}()
+
+	frames, err := readRawFrames(src)
+
+	assert.Nil(t, err)
+
+	if !assert.Equal(t, 3, len(frames)) {
+		return
+	}
+
+	assert.Equal(t, byte(TextMessage), frames[0].header.Opcode())
+	assert.Equal(t, byte(ContinuationFrame), frames[1].header.Opcode())
+	assert.Equal(t, byte(ContinuationFrame), frames[2].header.Opcode())
+
+	assert.False(t, frames[0].header.Final())
+	assert.False(t, frames[1].header.Final())
+	assert.True(t, frames[2].header.Final())
+
+	assert.Equal(t, "hell", string(frames[0].payload))
+	assert.Equal(t, "o wo", string(frames[1].payload))
+	assert.Equal(t, "rld", string(frames[2].payload))
+}
+
+func TestNextWriterJSONEncoder(t *testing.T) {
+	src, ws, err := wsPipe(nil)
+
+	assert.Nil(t, err)
+	assert.Nil(t, ws.SetWriteFrameSize(8))
+
+	value := map[string]string{"channel": "prices", "action": "subscribe"}
+
+	go func() {
+		w, _ := ws.NextWriter(TextMessage)
+
+		json.NewEncoder(w).Encode(value)
+		w.Close()
+	}()
+
+	frames, err := readRawFrames(src)
+
+	assert.Nil(t, err)
+
+	var message bytes.Buffer
+
+	for _, frame := range frames {
+		assert.True(t, frame.header.mask, "Expected client frames to be masked")
+		message.Write(frame.payload)
+	}
+
+	var decoded map[string]string
+
+	assert.Nil(t, json.Unmarshal(message.Bytes(), &decoded))
+	assert.Equal(t, value, decoded)
+}
+
+func TestNextWriterEmptyMessage(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	go func() {
+		w, _ := ws.NextWriter(BinaryMessage)
+		w.Close()
+	}()
+
+	frames, err := readRawFrames(src)
+
+	assert.Nil(t, err)
+
+	if assert.Equal(t, 1, len(frames)) {
+		assert.True(t, frames[0].header.Final())
+		assert.Equal(t, byte(BinaryMessage), frames[0].header.Opcode())
+		assert.Equal(t, int64(0), frames[0].header.PayloadLength())
+	}
+}
+
+func TestNextWriterClosed(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	go ioutil.ReadAll(src)
+
+	w, err := ws.NextWriter(TextMessage)
+
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+
+	_, err = w.Write([]byte("late"))
+
+	assert.Equal(t, errWriteClosed, err)
+	assert.Equal(t, errWriteClosed, w.Close())
+}
+
+func TestNextWriterBadOpcode(t *testing.T) {
+	_, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	_, err = ws.NextWriter(PingMessage)
+
+	assert.Equal(t, errBadWriteOpcode, err)
+	assert.Equal(t, errInvalidFrameSize, ws.SetWriteFrameSize(0))
+}
+
+func TestNextWriterCompressedFragments(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	ws.setExtensions([]ExtensionConn{newCompression(true, deflateParams{})})
+	ws.SetWriteFrameSize(16)
+
+	message := bytes.Repeat([]byte("0123456789abcdef"), 64)
+
+	go func() {
+		w, _ := ws.NextWriter(BinaryMessage)
+
+		w.Write(message)
+		w.Close()
+	}()
+
+	frames, err := readRawFrames(src)
+
+	assert.Nil(t, err)
+
+	// RSV1 marks the message as compressed on the first frame only, see
+	// rfc7692#section-6.1
+
+	var compressed []byte
+
+	for i, frame := range frames {
+		if i == 0 {
+			assert.Equal(t, byte(RSV1), frame.header.RSV())
+		} else {
+			assert.Equal(t, byte(0), frame.header.RSV())
+		}
+
+		compressed = append(compressed, frame.payload...)
+	}
+
+	_, client := compressionPair(deflateParams{})
+	decompressed, err := decompress(client, compressed)
+
+	assert.Nil(t, err)
+	assert.Equal(t, message, decompressed)
+}
+
+func TestWriteMessageControlFrame(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	go ws.Send(PingMessage, []byte("ping"))
+
+	frames, err := readRawFrames(src)
+
+	assert.Nil(t, err)
+
+	if assert.Equal(t, 1, len(frames)) {
+		assert.True(t, frames[0].header.Final(), "Expected control frames to set FIN")
+		assert.Equal(t, "ping", string(frames[0].payload))
+	}
+
+	err = ws.Send(PingMessage, make([]byte, maxControlFramePayloadLength+1))
+
+	assert.Equal(t, errControlFrameTooLarge, err)
+}
+
+func TestWriteMessageSingleFrame(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	message := bytes.Repeat([]byte("a"), 3*defaultWriteFrameSize)
+
+	go ws.Send(BinaryMessage, message)
+
+	frames, err := readRawFrames(src)
+
+	assert.Nil(t, err)
+
+	if assert.Equal(t, 1, len(frames), "Expected a message of known length in a single frame") {
+		assert.Equal(t, message, frames[0].payload)
+	}
+}
+
+func TestClientWritesLeaveInput(t *testing.T) {
+	src, ws, err := wsPipe(nil)
+
+	assert.Nil(t, err)
+
+	ws.SetWriteFrameSize(4)
+	ws.FrameType = TextMessage
+
+	text := []byte("hello websocket")
+	ping := []byte("ping")
+	original := append([]byte(nil), text...)
+
+	go func() {
+		ws.Send(TextMessage, text)
+		ws.Send(PingMessage, ping)
+		ws.Write(text)
+
+		w, _ := ws.NextWriter(BinaryMessage)
+		w.Write(text)
+		w.Close()
+	}()
+
+	for i := 0; i < 4; i++ {
+		frames, err := readRawFrames(src)
+
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		var message []byte
+
+		for _, frame := range frames {
+			message = append(message, frame.payload...)
+		}
+
+		if i == 1 {
+			assert.Equal(t, "ping", string(message))
+		} else {
+			assert.Equal(t, original, message)
+		}
+	}
+
+	// The frames were masked without touching the caller's buffers
+	assert.Equal(t, original, text)
+	assert.Equal(t, "ping", string(ping))
+}
@@ -0,0 +1,160 @@
+package websocket
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ServeMux is a websocket request multiplexer. It matches the path of each
+// incoming request against the registered patterns, upgrades the request to
+// a websocket connection and calls the handler of the pattern that matched.
+//
+// Patterns are slash separated paths, a segment of the form {name} matches
+// any single non empty path segment and its value is exposed through
+// Conn.Param(name). When several patterns match a request the pattern with
+// the most literal segments wins.
+type ServeMux struct {
+	// Upgrader is used to upgrade matched requests to websocket connections
+	Upgrader Upgrader
+
+	mu      sync.RWMutex
+	entries []muxEntry
+}
+
+type muxEntry struct {
+	pattern  string
+	segments []string
+	literals int
+	handler  func(*Conn)
+}
+
+// DefaultServeMux is the ServeMux used by HandleFunc
+var DefaultServeMux = NewServeMux()
+
+// NewServeMux allocates and returns a new ServeMux
+func NewServeMux() *ServeMux {
+	return &ServeMux{}
+}
+
+// HandleFunc registers the handler func for the given pattern to the DefaultServeMux
+func HandleFunc(pattern string, handler func(*Conn)) {
+	DefaultServeMux.HandleFunc(pattern, handler)
+}
+
+// HandleFunc registers the handler func for the given pattern
+func (mux *ServeMux) HandleFunc(pattern string, handler func(*Conn)) {
+	if !strings.HasPrefix(pattern, "/") {
+		panic("websocket: pattern must start with a slash: " + pattern)
+	}
+
+	if handler == nil {
+		panic("websocket: nil handler for pattern " + pattern)
+	}
+
+	segments := splitPath(pattern)
+	literals := 0
+
+	for _, segment := range segments {
+		if !isParamSegment(segment) {
+			literals++
+		}
+	}
+
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	for _, entry := range mux.entries {
+		if entry.pattern == pattern {
+			panic("websocket: multiple registrations for " + pattern)
+		}
+	}
+
+	mux.entries = append(mux.entries, muxEntry{pattern, segments, literals, handler})
+}
+
+// Handler returns the handler and path parameters for the given request path,
+// ok is false when none of the registered patterns match
+func (mux *ServeMux) Handler(path string) (handler func(*Conn), params map[string]string, ok bool) {
+	segments := splitPath(path)
+
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+
+	best := -1
+
+	for _, entry := range mux.entries {
+		if entry.literals <= best {
+			continue
+		}
+
+		if p, match := entry.match(segments); match {
+			handler, params, best = entry.handler, p, entry.literals
+		}
+	}
+
+	return handler, params, best >= 0
+}
+
+// ServeHTTP upgrades the request to a websocket connection and dispatches it
+// to the handler whose pattern matches the request path
+func (mux *ServeMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler, params, ok := mux.Handler(r.URL.Path)
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	conn, err := mux.Upgrader.Upgrade(w, r, nil)
+
+	if err != nil {
+		log.Println("Unable to upgrade connection", r.URL.Path, err)
+		return
+	}
+
+	defer conn.rwc.Close()
+
+	conn.params = params
+
+	handler(conn)
+}
+
+func (entry muxEntry) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(entry.segments) {
+		return nil, false
+	}
+
+	var params map[string]string
+
+	for i, segment := range entry.segments {
+		if isParamSegment(segment) {
+			if segments[i] == "" {
+				return nil, false
+			}
+
+			if params == nil {
+				params = make(map[string]string)
+			}
+
+			params[segment[1:len(segment)-1]] = segments[i]
+
+			continue
+		}
+
+		if segment != segments[i] {
+			return nil, false
+		}
+	}
+
+	return params, true
+}
+
+func isParamSegment(segment string) bool {
+	return len(segment) > 2 && segment[0] == '{' && segment[len(segment)-1] == '}'
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
+}
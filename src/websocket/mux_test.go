@@ -0,0 +1,103 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeMuxHandler(t *testing.T) {
+	mux := NewServeMux()
+
+	called := ""
+
+	mux.HandleFunc("/chat", func(*Conn) { called = "chat" })
+	mux.HandleFunc("/rooms/{id}", func(*Conn) { called = "room" })
+	mux.HandleFunc("/rooms/lobby", func(*Conn) { called = "lobby" })
+	mux.HandleFunc("/rooms/{id}/users/{user}", func(*Conn) { called = "user" })
+
+	lookup := func(path string) (string, map[string]string, bool) {
+		called = ""
+
+		handler, params, ok := mux.Handler(path)
+
+		if ok {
+			handler(&Conn{})
+		}
+
+		return called, params, ok
+	}
+
+	name, params, ok := lookup("/chat")
+
+	assert.True(t, ok)
+	assert.Equal(t, "chat", name)
+	assert.Equal(t, 0, len(params))
+
+	name, params, ok = lookup("/rooms/42")
+
+	assert.True(t, ok)
+	assert.Equal(t, "room", name)
+	assert.Equal(t, map[string]string{"id": "42"}, params)
+
+	name, _, ok = lookup("/rooms/lobby")
+
+	assert.True(t, ok)
+	assert.Equal(t, "lobby", name, "Expected literal segments to win over parameters")
+
+	name, params, ok = lookup("/rooms/42/users/alice")
+
+	assert.True(t, ok)
+	assert.Equal(t, "user", name)
+	assert.Equal(t, map[string]string{"id": "42", "user": "alice"}, params)
+
+	_, _, ok = lookup("/rooms/")
+	assert.False(t, ok, "Expected empty parameter segment not to match")
+
+	_, _, ok = lookup("/unknown")
+	assert.False(t, ok)
+}
+
+func TestServeMuxDuplicatePattern(t *testing.T) {
+	mux := NewServeMux()
+	mux.HandleFunc("/chat", func(*Conn) {})
+
+	defer func() {
+		assert.NotNil(t, recover(), "Expected duplicate registration to panic")
+	}()
+
+	mux.HandleFunc("/chat", func(*Conn) {})
+}
+
+func TestServeMuxNotFound(t *testing.T) {
+	mux := NewServeMux()
+	mux.HandleFunc("/chat", func(*Conn) {})
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/other", nil))
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestServeMuxParams(t *testing.T) {
+	mux := NewServeMux()
+	params := make(chan string, 1)
+
+	mux.HandleFunc("/rooms/{id}", func(conn *Conn) {
+		params <- conn.Param("id")
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	conn, err := Dial(strings.Replace(server.URL, "http", "ws", 1) + "/rooms/kitchen")
+
+	assert.Nil(t, err)
+
+	assert.Equal(t, "kitchen", <-params)
+
+	conn.rwc.Close()
+}
@@ -1,5 +1,9 @@
 package websocket
 
+import (
+	"fmt"
+)
+
 // OpError encapsulates a websocket operational error
 // e.g. we receive a control frame with a payload length > 125
 type OpError struct {
@@ -8,3 +12,13 @@ type OpError struct {
 func (err *OpError) Error() string {
 	return "Operation ERROR"
 }
+
+// ReadLimitError is returned when a message is larger than the connection's
+// read limit, see Conn.SetReadLimit
+type ReadLimitError struct {
+	Limit int64
+}
+
+func (err *ReadLimitError) Error() string {
+	return fmt.Sprintf("websocket: message exceeds the read limit of %d bytes", err.Limit)
+}
@@ -29,6 +29,17 @@ func NewPayloadReader(reader io.Reader, header FrameHeader) PayloadReader {
 	return &payloadReader{reader, header, header.payloadLength}
 }
 
+// reset reuses r for the payload of the next frame read from reader, masked
+// is reused to unmask the payload of a masked frame
+func (r *payloadReader) reset(reader io.Reader, header FrameHeader, masked *MaskedReader) {
+	if header.mask {
+		*masked = MaskedReader{reader, header.maskBytes, 0}
+		reader = masked
+	}
+
+	*r = payloadReader{reader, header, header.payloadLength}
+}
+
 // PayloadType returns the opcode of the current frame
 func (r payloadReader) PayloadType() byte {
 	return r.header.opcode
@@ -51,17 +62,16 @@ func (r *payloadReader) Read(b []byte) (n int, err error) {
 
 	if int64(len(b)) > r.readRemaining {
 		b = b[:r.readRemaining]
-
-		n, err := r.reader.Read(b)
-
-		r.readRemaining -= int64(n)
-
-		return n, err
 	}
 
 	n, err = r.reader.Read(b)
 
 	r.readRemaining -= int64(n)
 
+	// The connection ended before the end of the frame
+	if err == io.EOF && r.readRemaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+
 	return n, err
 }
@@ -10,18 +10,31 @@ func (err ProtocolError) Error() string {
 }
 
 var (
-	ErrBadProtocolVersion   = &ProtocolError{"bad protocol version"}
-	ErrBadScheme            = &ProtocolError{"bad scheme"}
-	ErrBadStatus            = &ProtocolError{"bad status"}
-	ErrBadUpgrade           = &ProtocolError{"missing or bad upgrade"}
-	ErrBadWebSocketOrigin   = &ProtocolError{"missing or bad WebSocket-Origin"}
-	ErrBadWebSocketLocation = &ProtocolError{"missing or bad WebSocket-Location"}
-	ErrBadWebSocketProtocol = &ProtocolError{"missing or bad WebSocket-Protocol"}
-	ErrBadWebSocketVersion  = &ProtocolError{"missing or bad WebSocket Version"}
-	ErrChallengeResponse    = &ProtocolError{"mismatch challenge/response"}
-	ErrBadFrame             = &ProtocolError{"bad frame"}
-	ErrBadFrameBoundary     = &ProtocolError{"not on frame boundary"}
-	ErrNotWebSocket         = &ProtocolError{"not websocket protocol"}
-	ErrBadRequestMethod     = &ProtocolError{"bad method"}
-	ErrNotSupported         = &ProtocolError{"not supported"}
+	ErrBadProtocolVersion    = &ProtocolError{"bad protocol version"}
+	ErrBadScheme             = &ProtocolError{"bad scheme"}
+	ErrBadStatus             = &ProtocolError{"bad status"}
+	ErrBadUpgrade            = &ProtocolError{"missing or bad upgrade"}
+	ErrBadWebSocketOrigin    = &ProtocolError{"missing or bad WebSocket-Origin"}
+	ErrBadWebSocketLocation  = &ProtocolError{"missing or bad WebSocket-Location"}
+	ErrBadWebSocketProtocol  = &ProtocolError{"missing or bad WebSocket-Protocol"}
+	ErrBadWebSocketVersion   = &ProtocolError{"missing or bad WebSocket Version"}
+	ErrBadWebSocketExtension = &ProtocolError{"missing or bad WebSocket-Extensions"}
+	ErrChallengeResponse     = &ProtocolError{"mismatch challenge/response"}
+	ErrBadFrame              = &ProtocolError{"bad frame"}
+	ErrBadFrameBoundary      = &ProtocolError{"not on frame boundary"}
+	ErrNotWebSocket          = &ProtocolError{"not websocket protocol"}
+	ErrBadRequestMethod      = &ProtocolError{"bad method"}
+	ErrNotSupported          = &ProtocolError{"not supported"}
+	ErrInvalidUTF8           = &ProtocolError{"invalid UTF-8 in text message"}
+	ErrBadCloseCode          = &ProtocolError{"bad close code"}
+	ErrBadClosePayload       = &ProtocolError{"bad close payload"}
+	ErrBadOpcode             = &ProtocolError{"unknown opcode"}
+	ErrBadRSV                = &ProtocolError{"reserved bits set without a negotiated extension"}
+	ErrFragmentedControl     = &ProtocolError{"fragmented control frame"}
+	ErrControlFrameLength    = &ProtocolError{"control frame payload longer than 125 bytes"}
+	ErrUnexpectedContinue    = &ProtocolError{"continuation frame outside a fragmented message"}
+	ErrUnfinishedMessage     = &ProtocolError{"data frame inside a fragmented message"}
+	ErrUnmaskedFrame         = &ProtocolError{"client frame not masked"}
+	ErrMaskedFrame           = &ProtocolError{"server frame masked"}
+	ErrContinuationRSV1      = &ProtocolError{"RSV1 set on a continuation frame"}
 )
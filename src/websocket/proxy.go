@@ -0,0 +1,231 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+var (
+	errUnsupportedProxy = errors.New("websocket: unsupported proxy scheme")
+	errSOCKSAuth        = errors.New("websocket: SOCKS5 proxy authentication failed")
+)
+
+// SOCKS5 protocol constants, see RFC 1928 and RFC 1929
+const (
+	socks5Version          = 0x05
+	socks5AuthNone         = 0x00
+	socks5AuthPassword     = 0x02
+	socks5AuthNoAcceptable = 0xff
+	socks5CommandConnect   = 0x01
+	socks5AddressIPv4      = 0x01
+	socks5AddressDomain    = 0x03
+	socks5AddressIPv6      = 0x04
+	socks5PasswordVersion  = 0x01
+)
+
+// proxyURL returns the proxy to use for the websocket URL u, the proxy func
+// is called with the http(s) equivalent of u so http.ProxyFromEnvironment
+// picks HTTP_PROXY for ws:// and HTTPS_PROXY for wss://
+func (d *Dialer) proxyURL(u *url.URL) (*url.URL, error) {
+	if d.Proxy == nil {
+		return nil, nil
+	}
+
+	return d.Proxy(&http.Request{Method: "GET", URL: httpURL(u), Header: http.Header{}, Host: u.Host})
+}
+
+// proxyAddress returns the host:port of the proxy using the default port of
+// the proxy's scheme when none is given
+func proxyAddress(proxy *url.URL) (string, error) {
+	var port string
+
+	switch proxy.Scheme {
+	case "http":
+		port = "80"
+	case "socks5", "socks5h":
+		port = "1080"
+	default:
+		return "", errUnsupportedProxy
+	}
+
+	if proxy.Port() != "" {
+		port = proxy.Port()
+	}
+
+	return net.JoinHostPort(proxy.Hostname(), port), nil
+}
+
+// proxyConnect asks the proxy on conn to open a tunnel to address
+func proxyConnect(conn net.Conn, proxy *url.URL, address string) error {
+	switch proxy.Scheme {
+	case "http":
+		return httpConnect(conn, proxy, address)
+	case "socks5", "socks5h":
+		return socks5Connect(conn, proxy, address)
+	}
+
+	return errUnsupportedProxy
+}
+
+// httpConnect opens a tunnel using the HTTP CONNECT method
+func httpConnect(conn net.Conn, proxy *url.URL, address string) error {
+	request := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: http.Header{},
+	}
+
+	if user := proxy.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+
+		request.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if err := request.Write(conn); err != nil {
+		return err
+	}
+
+	// The tunnel only carries data once we start the websocket handshake so
+	// nothing can be buffered beyond the response
+	response, err := http.ReadResponse(bufio.NewReader(conn), request)
+
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("websocket: proxy CONNECT failed: %s", response.Status)
+	}
+
+	return nil
+}
+
+// socks5Connect opens a tunnel using the SOCKS5 CONNECT command
+func socks5Connect(conn net.Conn, proxy *url.URL, address string) error {
+	host, portString, err := net.SplitHostPort(address)
+
+	if err != nil {
+		return err
+	}
+
+	port, err := strconv.Atoi(portString)
+
+	if err != nil {
+		return err
+	}
+
+	// 1 : Negotiate the authentication method
+
+	methods := []byte{socks5AuthNone}
+
+	if proxy.User != nil {
+		methods = append(methods, socks5AuthPassword)
+	}
+
+	greeting := append([]byte{socks5Version, byte(len(methods))}, methods...)
+
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+
+	if reply[0] != socks5Version || reply[1] == socks5AuthNoAcceptable {
+		return errSOCKSAuth
+	}
+
+	// 2 : Username/password authentication, see RFC 1929
+
+	if reply[1] == socks5AuthPassword {
+		if proxy.User == nil {
+			return errSOCKSAuth
+		}
+
+		username := proxy.User.Username()
+		password, _ := proxy.User.Password()
+
+		auth := []byte{socks5PasswordVersion, byte(len(username))}
+		auth = append(auth, username...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+
+		if reply[1] != 0 {
+			return errSOCKSAuth
+		}
+	}
+
+	// 3 : Request the connection to the target address
+
+	request := []byte{socks5Version, socks5CommandConnect, 0}
+
+	if ip := net.ParseIP(host); ip == nil {
+		request = append(request, socks5AddressDomain, byte(len(host)))
+		request = append(request, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		request = append(request, socks5AddressIPv4)
+		request = append(request, ip4...)
+	} else {
+		request = append(request, socks5AddressIPv6)
+		request = append(request, ip.To16()...)
+	}
+
+	request = append(request, byte(port>>8), byte(port))
+
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	// 4 : Read the reply, the bound address is not used
+
+	header := make([]byte, 4)
+
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+
+	if header[1] != 0 {
+		return fmt.Errorf("websocket: SOCKS5 proxy CONNECT failed with code %d", header[1])
+	}
+
+	var boundLength int
+
+	switch header[3] {
+	case socks5AddressIPv4:
+		boundLength = net.IPv4len
+	case socks5AddressIPv6:
+		boundLength = net.IPv6len
+	case socks5AddressDomain:
+		if _, err := io.ReadFull(conn, header[:1]); err != nil {
+			return err
+		}
+
+		boundLength = int(header[0])
+	default:
+		return errors.New("websocket: SOCKS5 proxy replied with an unknown address type")
+	}
+
+	_, err = io.ReadFull(conn, make([]byte, boundLength+2))
+
+	return err
+}
@@ -0,0 +1,308 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Default ReconnectingConn settings
+const (
+	defaultMinBackoff    = 500 * time.Millisecond
+	defaultMaxBackoff    = 30 * time.Second
+	defaultBackoffFactor = 2
+)
+
+var (
+	// ErrNotConnected is returned by ReconnectingConn.Send while disconnected
+	// when outgoing messages are not buffered
+	ErrNotConnected = errors.New("websocket: not connected")
+
+	// ErrSendBufferFull is returned by ReconnectingConn.Send while
+	// disconnected when SendBufferSize messages are already buffered
+	ErrSendBufferFull = errors.New("websocket: send buffer full")
+
+	// ErrReconnectingConnClosed is returned after Close was called
+	ErrReconnectingConnClosed = errors.New("websocket: reconnecting connection closed")
+)
+
+// ReconnectingConn is a client connection that redials the server with
+// exponential backoff whenever the connection drops
+type ReconnectingConn struct {
+	// URL is the ws:// or wss:// URL to dial
+	URL string
+
+	// Header holds additional handshake request headers
+	Header http.Header
+
+	// Dialer is used to dial the server, DefaultDialer when nil
+	Dialer *Dialer
+
+	// MinBackoff and MaxBackoff bound the wait between dial attempts, the
+	// wait is multiplied by BackoffFactor after each failed attempt and a
+	// random jitter of up to half the wait is subtracted
+	MinBackoff    time.Duration
+	MaxBackoff    time.Duration
+	BackoffFactor float64
+
+	// PingInterval is the interval at which pings are sent, when no pong is
+	// received within PingInterval + PongTimeout the connection is considered
+	// dropped, see Conn.SetKeepAlive. Zero disables pings.
+	PingInterval time.Duration
+	PongTimeout  time.Duration
+
+	// SendBufferSize is the number of messages Send buffers while
+	// disconnected, they are sent once connected. Zero disables buffering.
+	SendBufferSize int
+
+	// OnConnect is called after every successful dial before buffered
+	// messages are sent, use it to resend subscriptions
+	OnConnect func(conn *Conn)
+
+	// OnDisconnect is called with the error that dropped the connection
+	OnDisconnect func(err error)
+
+	// OnMessage is called for every received data message
+	OnMessage func(opcode byte, message []byte)
+
+	mu      sync.Mutex
+	conn    *Conn
+	pending []bufferedMessage
+	closed  bool
+	cancel  context.CancelFunc
+}
+
+type bufferedMessage struct {
+	opcode  byte
+	message []byte
+}
+
+// NewReconnectingConn returns a ReconnectingConn for url with the default
+// backoff settings
+func NewReconnectingConn(url string, dialer *Dialer) *ReconnectingConn {
+	return &ReconnectingConn{
+		URL:           url,
+		Dialer:        dialer,
+		MinBackoff:    defaultMinBackoff,
+		MaxBackoff:    defaultMaxBackoff,
+		BackoffFactor: defaultBackoffFactor,
+	}
+}
+
+// Run dials the server and keeps the connection alive until ctx is done or
+// Close is called
+func (rc *ReconnectingConn) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	rc.mu.Lock()
+
+	if rc.closed {
+		rc.mu.Unlock()
+		return ErrReconnectingConnClosed
+	}
+
+	rc.cancel = cancel
+	rc.mu.Unlock()
+
+	dialer := rc.Dialer
+
+	if dialer == nil {
+		dialer = DefaultDialer
+	}
+
+	attempt := 0
+
+	for {
+		conn, _, err := dialer.DialContext(ctx, rc.URL, rc.Header)
+
+		if err == nil {
+			attempt = 0
+			err = rc.serve(ctx, conn)
+
+			log.Println("Reconnecting connection dropped", err)
+
+			if rc.OnDisconnect != nil {
+				rc.OnDisconnect(err)
+			}
+		} else {
+			log.Println("Reconnecting dial failed", err)
+		}
+
+		// 1 : Wait before dialing again
+
+		timer := time.NewTimer(rc.backoff(attempt))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return rc.runError(ctx)
+		case <-timer.C:
+		}
+
+		attempt++
+	}
+}
+
+func (rc *ReconnectingConn) runError(ctx context.Context) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.closed {
+		return ErrReconnectingConnClosed
+	}
+
+	return ctx.Err()
+}
+
+// backoff returns the wait before the given dial attempt, zero settings
+// fall back to the defaults
+func (rc *ReconnectingConn) backoff(attempt int) time.Duration {
+	minBackoff, maxBackoff, factor := rc.MinBackoff, rc.MaxBackoff, rc.BackoffFactor
+
+	if minBackoff <= 0 {
+		minBackoff = defaultMinBackoff
+	}
+
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+
+	if factor < 1 {
+		factor = defaultBackoffFactor
+	}
+
+	wait := float64(minBackoff)
+
+	for i := 0; i < attempt && wait < float64(maxBackoff); i++ {
+		wait *= factor
+	}
+
+	if wait > float64(maxBackoff) {
+		wait = float64(maxBackoff)
+	}
+
+	// Jitter keeps clients from redialing a restarted server all at once
+	return time.Duration(wait/2 + rand.Float64()*wait/2)
+}
+
+// serve reads from conn until it fails
+func (rc *ReconnectingConn) serve(ctx context.Context, conn *Conn) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	// Unblock the read when Run is cancelled
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.rwc.Close()
+		case <-done:
+		}
+	}()
+
+	defer func() {
+		rc.mu.Lock()
+		rc.conn = nil
+		rc.mu.Unlock()
+
+		conn.rwc.Close()
+	}()
+
+	// 1 : Keep the connection alive with pings
+
+	conn.SetKeepAlive(rc.PingInterval, rc.PongTimeout)
+	defer conn.SetKeepAlive(0, 0)
+
+	// 2 : Let the user resubscribe and send the buffered messages
+
+	if rc.OnConnect != nil {
+		rc.OnConnect(conn)
+	}
+
+	if err := rc.connected(conn); err != nil {
+		return err
+	}
+
+	// 3 : Read until the connection drops
+
+	for {
+		opcode, message, err := conn.Receive()
+
+		if err != nil {
+			return err
+		}
+
+		if rc.OnMessage != nil {
+			rc.OnMessage(opcode, message)
+		}
+	}
+}
+
+// connected sends the buffered messages and makes conn available to Send
+func (rc *ReconnectingConn) connected(conn *Conn) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	for len(rc.pending) > 0 {
+		if err := conn.Send(rc.pending[0].opcode, rc.pending[0].message); err != nil {
+			return err
+		}
+
+		rc.pending = rc.pending[1:]
+	}
+
+	rc.pending = nil
+	rc.conn = conn
+
+	return nil
+}
+
+// Send sends a message on the current connection, while disconnected the
+// message is buffered when SendBufferSize allows it
+func (rc *ReconnectingConn) Send(opcode byte, b []byte) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.closed {
+		return ErrReconnectingConnClosed
+	}
+
+	if rc.conn != nil {
+		return rc.conn.Send(opcode, b)
+	}
+
+	if rc.SendBufferSize == 0 {
+		return ErrNotConnected
+	}
+
+	if len(rc.pending) >= rc.SendBufferSize {
+		return ErrSendBufferFull
+	}
+
+	message := make([]byte, len(b))
+	copy(message, b)
+
+	rc.pending = append(rc.pending, bufferedMessage{opcode, message})
+
+	return nil
+}
+
+// Close stops reconnecting and closes the current connection
+func (rc *ReconnectingConn) Close() error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.closed = true
+	rc.pending = nil
+
+	if rc.cancel != nil {
+		rc.cancel()
+	}
+
+	return nil
+}
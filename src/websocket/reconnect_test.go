@@ -0,0 +1,287 @@
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestReconnectingConn(server *httptest.Server) *ReconnectingConn {
+	rc := NewReconnectingConn(wsURL(server), &Dialer{})
+	rc.MinBackoff = 10 * time.Millisecond
+	rc.MaxBackoff = 50 * time.Millisecond
+
+	return rc
+}
+
+func TestReconnectingConnBackoff(t *testing.T) {
+	rc := NewReconnectingConn("ws://example.com", nil)
+	rc.MinBackoff = 100 * time.Millisecond
+	rc.MaxBackoff = time.Second
+
+	between := func(attempt int, min, max time.Duration) {
+		wait := rc.backoff(attempt)
+
+		assert.True(t, wait >= min && wait <= max, "Unexpected backoff", attempt, wait)
+	}
+
+	between(0, 50*time.Millisecond, 100*time.Millisecond)
+	between(3, 400*time.Millisecond, 800*time.Millisecond)
+	between(10, 500*time.Millisecond, time.Second)
+
+	// Zero settings use the defaults
+	rc = &ReconnectingConn{}
+	between(0, defaultMinBackoff/2, defaultMinBackoff)
+	between(100, defaultMaxBackoff/2, defaultMaxBackoff)
+}
+
+func TestReconnectingConnSendBuffer(t *testing.T) {
+	rc := NewReconnectingConn("ws://example.com", nil)
+
+	assert.Equal(t, ErrNotConnected, rc.Send(TextMessage, []byte("a")))
+
+	rc.SendBufferSize = 2
+
+	assert.Nil(t, rc.Send(TextMessage, []byte("a")))
+	assert.Nil(t, rc.Send(TextMessage, []byte("b")))
+	assert.Equal(t, ErrSendBufferFull, rc.Send(TextMessage, []byte("c")))
+
+	rc.Close()
+
+	assert.Equal(t, ErrReconnectingConnClosed, rc.Send(TextMessage, []byte("d")))
+	assert.Equal(t, ErrReconnectingConnClosed, rc.Run(context.Background()))
+}
+
+func TestReconnectingConnReconnects(t *testing.T) {
+	upgrader := &Upgrader{}
+	received := make(chan string, 10)
+
+	var connections int32
+
+	// The server drops the first connection after reading two messages
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+
+		if err != nil {
+			return
+		}
+
+		defer conn.rwc.Close()
+
+		n := atomic.AddInt32(&connections, 1)
+
+		for i := 1; n > 1 || i <= 2; i++ {
+			frame, err := readRawFrame(conn.brw)
+
+			if err != nil {
+				return
+			}
+
+			received <- string(frame.payload)
+		}
+	}))
+	defer server.Close()
+
+	rc := newTestReconnectingConn(server)
+	rc.SendBufferSize = 1
+
+	var connects, disconnects int32
+
+	rc.OnConnect = func(conn *Conn) {
+		atomic.AddInt32(&connects, 1)
+		conn.Send(TextMessage, []byte("subscribe"))
+	}
+
+	rc.OnDisconnect = func(err error) {
+		atomic.AddInt32(&disconnects, 1)
+	}
+
+	assert.Nil(t, rc.Send(TextMessage, []byte("buffered")))
+
+	result := make(chan error, 1)
+
+	go func() {
+		result <- rc.Run(context.Background())
+	}()
+
+	// Subscriptions are resent on every connection before buffered messages
+
+	expected := []string{"subscribe", "buffered", "subscribe"}
+
+	for _, message := range expected {
+		select {
+		case got := <-received:
+			assert.Equal(t, message, got)
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for", message)
+		}
+	}
+
+	rc.Close()
+
+	assert.Equal(t, ErrReconnectingConnClosed, <-result)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&connects))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&disconnects))
+}
+
+func TestReconnectingConnOnMessage(t *testing.T) {
+	upgrader := &Upgrader{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+
+		if err != nil {
+			return
+		}
+
+		defer conn.rwc.Close()
+
+		conn.rwc.Write([]byte("\x81\x02hi"))
+		readRawFrame(conn.brw)
+	}))
+	defer server.Close()
+
+	messages := make(chan string, 1)
+
+	rc := newTestReconnectingConn(server)
+	rc.OnMessage = func(opcode byte, message []byte) {
+		assert.Equal(t, byte(TextMessage), opcode)
+		messages <- string(message)
+	}
+
+	go rc.Run(context.Background())
+	defer rc.Close()
+
+	select {
+	case message := <-messages:
+		assert.Equal(t, "hi", message)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for message")
+	}
+}
+
+func TestReconnectingConnMissedPong(t *testing.T) {
+	upgrader := &Upgrader{}
+	release := make(chan struct{})
+
+	// The server never reads so it never answers our pings
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+
+		if err != nil {
+			return
+		}
+
+		<-release
+		conn.rwc.Close()
+	}))
+	defer server.Close()
+	defer close(release)
+
+	disconnected := make(chan error, 1)
+
+	rc := newTestReconnectingConn(server)
+	rc.PingInterval = 20 * time.Millisecond
+	rc.PongTimeout = 20 * time.Millisecond
+	rc.OnDisconnect = func(err error) {
+		select {
+		case disconnected <- err:
+		default:
+		}
+	}
+
+	go rc.Run(context.Background())
+	defer rc.Close()
+
+	select {
+	case err := <-disconnected:
+		assert.True(t, IsCloseError(err, CloseStatusAbnormalClosure), "Expected an abnormal closure", err)
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Expected the missing pong to drop the connection")
+	}
+}
+
+func TestReconnectingConnPong(t *testing.T) {
+	upgrader := &Upgrader{}
+	pings := make(chan struct{}, 100)
+
+	// The server answers every ping with a pong
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+
+		if err != nil {
+			return
+		}
+
+		defer conn.rwc.Close()
+
+		for {
+			frame, err := readRawFrame(conn.brw)
+
+			if err != nil {
+				return
+			}
+
+			if frame.header.Opcode() == PingMessage {
+				conn.rwc.Write([]byte{0x8a, 0x00})
+
+				select {
+				case pings <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}))
+	defer server.Close()
+
+	connected := make(chan struct{}, 1)
+	disconnected := make(chan error, 1)
+
+	rc := newTestReconnectingConn(server)
+	rc.PingInterval = 20 * time.Millisecond
+	rc.PongTimeout = 20 * time.Millisecond
+	rc.OnConnect = func(*Conn) {
+		connected <- struct{}{}
+	}
+	rc.OnDisconnect = func(err error) {
+		select {
+		case disconnected <- err:
+		default:
+		}
+	}
+
+	go rc.Run(context.Background())
+	defer rc.Close()
+
+	select {
+	case <-connected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the connection")
+	}
+
+	// Several pong timeouts pass while the server answers the pings
+
+	for i := 0; i < 5; i++ {
+		select {
+		case <-pings:
+		case err := <-disconnected:
+			t.Fatal("Expected pongs to keep the connection alive", err)
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for a ping")
+		}
+	}
+
+	select {
+	case err := <-disconnected:
+		t.Fatal("Expected pongs to keep the connection alive", err)
+	default:
+	}
+}
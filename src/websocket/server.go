@@ -4,7 +4,6 @@ import (
 	"crypto/sha1"
 	"encoding/base64"
 	"io"
-	"log"
 	"net/http"
 	"time"
 )
@@ -25,75 +24,13 @@ func (err *HttpError) Error() string {
 	return err.Message
 }
 
-// HandleFunc registers the handler func for the given pattern to the DefaultServerMux
-func HandleFunc(pattern string, handler func(Conn)) {
-	// Wrap internal http server HandleFunc
-
-	http.HandleFunc("/chat", func(w http.ResponseWriter, r *http.Request) {
-		log.Println("Handeling new HTTP connection")
-
-		// Validate the Request to be a request for a websocket conn upgrade
-
-		invalid := validateRequest(r)
-
-		if invalid != nil {
-			log.Println("Bad Request", invalid)
-
-			http.Error(w, invalid.Message, invalid.Code)
-
-			return
-		}
-
-		// Send ack handshake to client
-
-		w.Header().Set("Upgrade", "websocket")
-		w.Header().Set("Connection", "Upgrade")
-		w.Header().Set("Sec-Websocket-Accept", createWebsocketSecHeader(r.Header.Get("Sec-Websocket-Key")))
-		w.WriteHeader(http.StatusSwitchingProtocols)
-
-		// Now Hijack this connection so we can send raw TCP
-
-		hj, ok := w.(http.Hijacker)
-
-		if !ok {
-			log.Println("Webserver doesn't support http connection hijack")
-
-			http.Error(w, "Webserver doesn't support websocket connection upgrade", http.StatusInternalServerError)
-			return
-		}
-
-		conn, bufrw, err := hj.Hijack()
-
-		if err != nil {
-			log.Println("Unable to hijack http connection with error", err)
-
-			http.Error(w, "Webserver doesn't support websocket connection upgrade", http.StatusInternalServerError)
-			return
-		}
-
-		defer conn.Close()
-
-		// Handle the Websocket Protocol on this connection
-
-		wsConn, err := NewConn(conn, bufrw, r)
-
-		if err != nil {
-			log.Println("Unable to create Websocket Connection", err)
-
-			http.Error(w, "Webserver doesn't support websocket connection upgrade", http.StatusInternalServerError)
-		}
-
-		handler(wsConn)
-	})
-}
-
 func createWebsocketSecHeader(input string) string {
 	hasher := sha1.New()
 
 	io.WriteString(hasher, input)
 	io.WriteString(hasher, websocketGUID)
 
-	return base64.URLEncoding.EncodeToString(hasher.Sum(nil))
+	return base64.StdEncoding.EncodeToString(hasher.Sum(nil))
 }
 
 func validateRequest(request *http.Request) *HttpError {
@@ -159,7 +96,7 @@ func validateRequest(request *http.Request) *HttpError {
 func CreateWSServer() *Server {
 	httpServer := &http.Server{
 		Addr:    ":8080",
-		Handler: nil,
+		Handler: DefaultServeMux,
 
 		WriteTimeout: 2 * time.Second,
 	}
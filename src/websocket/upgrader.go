@@ -0,0 +1,183 @@
+package websocket
+
+import (
+	"bufio"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	errHijackNotSupported = errors.New("Webserver doesn't support websocket connection upgrade")
+)
+
+// Upgrader upgrades HTTP requests to websocket connections, it can be used
+// from within any http.Handler
+type Upgrader struct {
+	// HandshakeTimeout is the maximum duration for writing the handshake
+	// response, zero means no timeout
+	HandshakeTimeout time.Duration
+
+	// ReadBufferSize and WriteBufferSize specify the I/O buffer sizes in bytes,
+	// when zero the buffers allocated by the HTTP server are reused
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	// WriteBufferPool is a pool of write buffers shared by the connections,
+	// when set a connection only holds a write buffer while writing a frame.
+	// The pool's buffers should be WriteBufferSize bytes.
+	WriteBufferPool BufferPool
+
+	// Subprotocols lists the application protocols supported by the server,
+	// the first protocol offered by the client that is in this list is
+	// selected. When responseHeader already contains Sec-WebSocket-Protocol
+	// that value is used instead.
+	Subprotocols []string
+
+	// EnableCompression specifies if the server should attempt to negotiate
+	// the permessage-deflate extension (RFC 7692) with the client
+	EnableCompression bool
+
+	// Extensions lists the extensions supported by the server, the client's
+	// offers are accepted in the order they were offered
+	Extensions []Extension
+
+	// Error is called to write the HTTP error response when the request can
+	// not be upgraded, http.Error is used when Error is nil
+	Error func(w http.ResponseWriter, r *http.Request, status int, reason error)
+}
+
+func (u *Upgrader) selectSubprotocol(r *http.Request, responseHeader http.Header) string {
+	if protocol := responseHeader.Get("Sec-WebSocket-Protocol"); protocol != "" {
+		return protocol
+	}
+
+	for _, protocol := range headerTokens(r.Header, "Sec-WebSocket-Protocol") {
+		if containsToken(u.Subprotocols, protocol) {
+			return protocol
+		}
+	}
+
+	return ""
+}
+
+func (u *Upgrader) returnError(w http.ResponseWriter, r *http.Request, status int, reason error) (*Conn, error) {
+	if u.Error != nil {
+		u.Error(w, r, status, reason)
+	} else {
+		http.Error(w, reason.Error(), status)
+	}
+
+	return nil, reason
+}
+
+// Upgrade validates the websocket handshake request, hijacks the underlying
+// connection and writes the handshake response including responseHeader. On
+// failure an HTTP error response has been written to w, unless the connection
+// was already hijacked.
+func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, error) {
+	// 1 : Validate the Request to be a request for a websocket conn upgrade
+
+	if invalid := validateRequest(r); invalid != nil {
+		return u.returnError(w, r, invalid.Code, invalid)
+	}
+
+	// 2 : Now Hijack this connection so we can send raw TCP
+
+	hj, ok := w.(http.Hijacker)
+
+	if !ok {
+		return u.returnError(w, r, http.StatusInternalServerError, errHijackNotSupported)
+	}
+
+	netConn, bufrw, err := hj.Hijack()
+
+	if err != nil {
+		return u.returnError(w, r, http.StatusInternalServerError, err)
+	}
+
+	reader := bufrw.Reader
+	writer := bufrw.Writer
+
+	// Data the client sent after the handshake request may already be buffered
+	// in the hijacked reader, only then is it wrapped instead of replaced
+
+	if u.ReadBufferSize > 0 {
+		if reader.Buffered() > 0 {
+			reader = bufio.NewReaderSize(reader, u.ReadBufferSize)
+		} else {
+			reader = bufio.NewReaderSize(netConn, u.ReadBufferSize)
+		}
+	}
+
+	if u.WriteBufferSize > 0 {
+		writer = bufio.NewWriterSize(netConn, u.WriteBufferSize)
+	}
+
+	// 3 : Send ack handshake to client
+
+	header := http.Header{}
+
+	for name, values := range responseHeader {
+		header[name] = values
+	}
+
+	header.Set("Upgrade", "websocket")
+	header.Set("Connection", "Upgrade")
+	header.Set("Sec-Websocket-Accept", createWebsocketSecHeader(r.Header.Get("Sec-Websocket-Key")))
+
+	subprotocol := u.selectSubprotocol(r, responseHeader)
+
+	if subprotocol != "" {
+		header.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+
+	accepted, extensions := acceptExtensions(withCompression(u.EnableCompression, u.Extensions), parseExtensions(r.Header))
+
+	if len(accepted) > 0 {
+		header.Set("Sec-WebSocket-Extensions", strings.Join(accepted, ", "))
+	}
+
+	if u.HandshakeTimeout > 0 {
+		netConn.SetWriteDeadline(time.Now().Add(u.HandshakeTimeout))
+	}
+
+	writer.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	header.Write(writer)
+	writer.WriteString("\r\n")
+
+	if err := writer.Flush(); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	if u.HandshakeTimeout > 0 {
+		netConn.SetWriteDeadline(time.Time{})
+	}
+
+	// 4 : Handle the Websocket Protocol on this connection
+
+	// Pooled write buffers replace the connection's own buffer
+
+	if u.WriteBufferPool != nil {
+		writer = nil
+	}
+
+	conn, err := NewConn(netConn, bufio.NewReadWriter(reader, writer), r)
+
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	conn.subprotocol = subprotocol
+	conn.setExtensions(extensions)
+	conn.writePool = u.WriteBufferPool
+
+	if u.WriteBufferSize > 0 {
+		conn.writeBufferSize = u.WriteBufferSize
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,160 @@
+package websocket
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func wsURL(server *httptest.Server) string {
+	return strings.Replace(server.URL, "http", "ws", 1)
+}
+
+func TestUpgraderUpgrade(t *testing.T) {
+	upgrader := &Upgrader{
+		HandshakeTimeout: time.Second,
+		ReadBufferSize:   1024,
+		WriteBufferSize:  1024,
+	}
+
+	received := make(chan string, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, http.Header{"X-Upgraded-By": {"test"}})
+
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		defer conn.rwc.Close()
+
+		_, msg, err := conn.Receive()
+
+		assert.Nil(t, err)
+
+		received <- string(msg)
+	}))
+	defer server.Close()
+
+	netConn, err := net.Dial("tcp", server.Listener.Addr().String())
+
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	defer netConn.Close()
+
+	request, err := createWSSRequest(wsURL(server), nil, &Dialer{})
+
+	assert.Nil(t, err)
+
+	request.Write(netConn)
+
+	response, err := http.ReadResponse(bufio.NewReader(netConn), request)
+
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, http.StatusSwitchingProtocols, response.StatusCode)
+	assert.Equal(t, "test", response.Header.Get("X-Upgraded-By"))
+	assert.Equal(t, "websocket", response.Header.Get("Upgrade"))
+
+	payload := []byte("upgraded")
+	fh := NewFrameHeader(true, TextMessage, true, [4]byte{0x1, 0x2, 0x3, 0x4}, int64(len(payload)))
+
+	netConn.Write(AppendFrameHeader(nil, fh))
+	NewMaskedWriter(netConn, fh.maskBytes).Write(payload)
+
+	assert.Equal(t, "upgraded", <-received)
+}
+
+func TestUpgraderBufferedFrame(t *testing.T) {
+	upgrader := &Upgrader{ReadBufferSize: 1024}
+	received := make(chan string, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		defer conn.rwc.Close()
+
+		_, msg, err := conn.Receive()
+
+		assert.Nil(t, err)
+
+		received <- string(msg)
+	}))
+	defer server.Close()
+
+	netConn, err := net.Dial("tcp", server.Listener.Addr().String())
+
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	defer netConn.Close()
+
+	request, err := createWSSRequest(wsURL(server), nil, &Dialer{})
+
+	assert.Nil(t, err)
+
+	// The frame is sent along with the request so the server buffers it while
+	// reading the request
+
+	var b strings.Builder
+
+	request.Write(&b)
+	writeTestFrame(&b, true, true, TextMessage, []byte("early"))
+
+	netConn.Write([]byte(b.String()))
+
+	response, err := http.ReadResponse(bufio.NewReader(netConn), request)
+
+	if assert.Nil(t, err) {
+		assert.Equal(t, http.StatusSwitchingProtocols, response.StatusCode)
+		assert.Equal(t, "early", <-received)
+	}
+}
+
+func TestUpgraderErrorCallback(t *testing.T) {
+	var status int
+	var reason error
+
+	upgrader := &Upgrader{
+		Error: func(w http.ResponseWriter, r *http.Request, s int, e error) {
+			status, reason = s, e
+
+			w.WriteHeader(http.StatusTeapot)
+		},
+	}
+
+	rec := httptest.NewRecorder()
+	conn, err := upgrader.Upgrade(rec, httptest.NewRequest("GET", "/", nil), nil)
+
+	assert.Nil(t, conn)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, err, reason)
+	assert.Equal(t, http.StatusTeapot, rec.Code)
+}
+
+func TestUpgraderDefaultError(t *testing.T) {
+	upgrader := &Upgrader{}
+
+	rec := httptest.NewRecorder()
+	_, err := upgrader.Upgrade(rec, httptest.NewRequest("POST", "/", nil), nil)
+
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "Unsupported request method\n", rec.Body.String())
+}
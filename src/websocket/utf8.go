@@ -0,0 +1,72 @@
+package websocket
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+// utf8Validator validates UTF-8 text written to it in pieces, a rune split
+// across two writes is kept until the rest of it is written
+type utf8Validator struct {
+	pending [utf8.UTFMax]byte
+	n       int
+}
+
+// validate reports if p continues the text with valid UTF-8
+func (v *utf8Validator) validate(p []byte) bool {
+	// Complete the rune split by the previous write
+
+	for v.n > 0 && len(p) > 0 {
+		v.pending[v.n] = p[0]
+		v.n++
+		p = p[1:]
+
+		if utf8.FullRune(v.pending[:v.n]) {
+			if !utf8.Valid(v.pending[:v.n]) {
+				return false
+			}
+
+			v.n = 0
+		}
+	}
+
+	// Find the start of the last rune, at most utf8.UTFMax-1 bytes back
+	start := len(p)
+
+	for i := 1; i < utf8.UTFMax && i <= len(p); i++ {
+		if utf8.RuneStart(p[len(p)-i]) {
+			start = len(p) - i
+			break
+		}
+	}
+
+	if start < len(p) && !utf8.FullRune(p[start:]) {
+		v.n = copy(v.pending[:], p[start:])
+		p = p[:start]
+	}
+
+	return utf8.Valid(p)
+}
+
+// complete reports if the text does not end in the middle of a rune
+func (v *utf8Validator) complete() bool {
+	return v.n == 0
+}
+
+// utf8Reader fails the connection when the text message read from r is not
+// valid UTF-8, see rfc6455#section-8.1
+type utf8Reader struct {
+	fspec     *FrameSpecHandler
+	r         io.Reader
+	validator utf8Validator
+}
+
+func (r *utf8Reader) Read(b []byte) (n int, err error) {
+	n, err = r.r.Read(b)
+
+	if !r.validator.validate(b[:n]) || (err == io.EOF && !r.validator.complete()) {
+		return n, r.fspec.failConnection(CloseStatusBadMessageData, "invalid UTF-8", ErrInvalidUTF8)
+	}
+
+	return n, err
+}
@@ -0,0 +1,168 @@
+package websocket
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUTF8ValidatorSplitRunes(t *testing.T) {
+	text := []byte("héllo wörld €𝄞 ✓")
+
+	// Every split of the text into two writes must be valid
+
+	for i := 0; i <= len(text); i++ {
+		v := utf8Validator{}
+
+		assert.True(t, v.validate(text[:i]), "Expected first part to be valid", i)
+		assert.True(t, v.validate(text[i:]), "Expected second part to be valid", i)
+		assert.True(t, v.complete(), "Expected text to end on a rune boundary", i)
+	}
+
+	// Byte by byte
+
+	v := utf8Validator{}
+
+	for i := range text {
+		assert.True(t, v.validate(text[i:i+1]))
+	}
+
+	assert.True(t, v.complete())
+}
+
+func TestUTF8ValidatorInvalid(t *testing.T) {
+	cases := [][][]byte{
+		{{0xff}},
+		{[]byte("abc"), {0xc0, 0x80}},
+		{{0xe2, 0x82}, {0x41}},
+		{{0xed, 0xa0}, {0x80}},
+		{{0xf4, 0x90, 0x80, 0x80}},
+		{{0x80, 0x80, 0x80, 0x80}},
+	}
+
+	for _, parts := range cases {
+		v := utf8Validator{}
+		valid := true
+
+		for _, part := range parts {
+			valid = valid && v.validate(part)
+		}
+
+		assert.False(t, valid, "Expected invalid UTF-8", parts)
+	}
+
+	// A text ending in the middle of a rune is incomplete
+
+	v := utf8Validator{}
+
+	assert.True(t, v.validate([]byte{0xe2, 0x82}))
+	assert.False(t, v.complete())
+}
+
+func TestReadInvalidUTF8(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	go writeTestFrame(src, true, true, TextMessage, []byte{'o', 'k', 0xff})
+
+	status := make(chan int, 1)
+
+	go func() {
+		status <- closeStatus(t, src)
+	}()
+
+	_, _, err = ws.Receive()
+
+	assert.Equal(t, ErrInvalidUTF8, err)
+	assert.Equal(t, CloseStatusBadMessageData, <-status)
+}
+
+func TestReadUTF8AcrossFragments(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	euro := []byte("€")
+
+	go func() {
+		writeTestFrame(src, true, false, TextMessage, euro[:1])
+		writeTestFrame(src, true, false, ContinuationFrame, euro[1:2])
+		writeTestFrame(src, true, true, ContinuationFrame, euro[2:])
+
+		// Binary messages are not validated
+		writeTestFrame(src, true, true, BinaryMessage, []byte{0xff})
+	}()
+
+	_, message, err := ws.Receive()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "€", string(message))
+
+	_, message, err = ws.Receive()
+
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0xff}, message)
+}
+
+func TestReadTruncatedUTF8(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	euro := []byte("€")
+
+	go func() {
+		writeTestFrame(src, true, false, TextMessage, euro[:1])
+		writeTestFrame(src, true, true, ContinuationFrame, euro[1:2])
+	}()
+
+	status := make(chan int, 1)
+
+	go func() {
+		status <- closeStatus(t, src)
+	}()
+
+	_, _, err = ws.Receive()
+
+	assert.Equal(t, ErrInvalidUTF8, err)
+	assert.Equal(t, CloseStatusBadMessageData, <-status)
+}
+
+func TestReadInvalidUTF8CloseReason(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	go writeTestFrame(src, true, true, CloseMessage, []byte{0x03, 0xe8, 0xff})
+
+	status := make(chan int, 1)
+
+	go func() {
+		status <- closeStatus(t, src)
+	}()
+
+	_, _, err = ws.Receive()
+
+	assert.Equal(t, ErrInvalidUTF8, err)
+	assert.Equal(t, CloseStatusBadMessageData, <-status)
+}
+
+func TestWriteUTF8Validation(t *testing.T) {
+	src, ws, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	go io.Copy(ioutil.Discard, src)
+
+	// Without validation the message is sent as is
+	assert.Nil(t, ws.Send(TextMessage, []byte{0xff}))
+
+	ws.EnableWriteUTF8Validation(true)
+
+	assert.Equal(t, ErrInvalidUTF8, ws.Send(TextMessage, []byte{0xff}))
+	assert.Nil(t, ws.Send(BinaryMessage, []byte{0xff}))
+}
@@ -1,11 +1,10 @@
 package websocket
 
 import (
-	"encoding/binary"
-	"errors"
 	"io"
-	"io/ioutil"
-	"log"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
 )
 
 // Bit masks used to parse control bits from frame header
@@ -27,25 +26,34 @@ const (
 	payloadLengthMask = 0x7f
 )
 
-// Closing status codes
 const (
 	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
 
-	closeStatusNormal            = 1000
-	closeStatusGoingAway         = 1001
-	closeStatusProtocolError     = 1002
-	closeStatusUnsupportedData   = 1003
-	closeStatusFrameTooLarge     = 1004
-	closeStatusNoStatusRcvd      = 1005
-	closeStatusAbnormalClosure   = 1006
-	closeStatusBadMessageData    = 1007
-	closeStatusPolicyViolation   = 1008
-	closeStatusTooBigData        = 1009
-	closeStatusExtensionMismatch = 1010
-
 	maxControlFramePayloadLength = 125
 )
 
+// Close status codes are defined in RFC 6455, section 7.4.1. The codes 1005,
+// 1006 and 1015 are never sent in a close frame, they only report how the
+// connection was closed.
+const (
+	CloseStatusNormal            = 1000
+	CloseStatusGoingAway         = 1001
+	CloseStatusProtocolError     = 1002
+	CloseStatusUnsupportedData   = 1003
+	CloseStatusFrameTooLarge     = 1004
+	CloseStatusNoStatusRcvd      = 1005
+	CloseStatusAbnormalClosure   = 1006
+	CloseStatusBadMessageData    = 1007
+	CloseStatusPolicyViolation   = 1008
+	CloseStatusTooBigData        = 1009
+	CloseStatusExtensionMismatch = 1010
+	CloseStatusInternalError     = 1011
+	CloseStatusServiceRestart    = 1012
+	CloseStatusTryAgainLater     = 1013
+	CloseStatusBadGateway        = 1014
+	CloseStatusTLSHandshake      = 1015
+)
+
 // The message types are defined in RFC 6455, section 11.8.
 const (
 	// ContinuationFrame denotes the continuation of a fragmented message.
@@ -74,287 +82,513 @@ const (
 
 type FrameHandler interface {
 	ReadMessage() (byte, []byte, error)
+	ReadMessageInto([]byte) (byte, []byte, error)
 	WriteMessage(byte, []byte) error
 	CloseConnection(int, string) error
 	NextReader() (byte, io.Reader, error)
-	NextWriter(byte, int64) (io.Writer, error)
+	NextWriter(byte) (io.WriteCloser, error)
 }
 
 // FrameSpecHandler handles websocket specification
 type FrameSpecHandler struct {
 	conn *Conn
+
+	// Closing handshake
+	mu       sync.Mutex
+	state    connState
+	closeErr *CloseError
+	closed   chan struct{}
+
+	// reader tells who reads the connection, the application once it starts
+	// reading or Close when the application never read, see startReading
+	reader int32
+
+	// writeMu makes frame writes atomic, messageMu is held from NextWriter
+	// until the message writer is closed so messages are never interleaved
+	writeMu   sync.Mutex
+	messageMu sync.Mutex
+
+	// closeSent is set once our close frame is written, guarded by writeMu
+	closeSent bool
+
+	// maskKeys hands out the masking keys of a client, guarded by writeMu
+	maskKeys maskKeySource
+
+	// The readers of the current frame are reused for every frame, header
+	// and control are scratch space for frame headers and control payloads
+	header   [8]byte
+	control  [maxControlFramePayloadLength]byte
+	payload  payloadReader
+	masked   MaskedReader
+	fragment fragmentReader
+	text     utf8Reader
+
+	// probe checks for the end of a message once ReadMessageInto's buffer is full
+	probe [1]byte
 }
 
 // NewFrameSpecHandler creates a new frame specification handler
 func NewFrameSpecHandler(conn *Conn) *FrameSpecHandler {
-	return &FrameSpecHandler{conn}
+	return &FrameSpecHandler{conn: conn, closed: make(chan struct{})}
 }
 
 func isControlFrameOpcode(opcode byte) bool {
 	return opcode == CloseMessage || opcode == PingMessage || opcode == PongMessage
 }
 
-func isBadFrame(fh FrameHeader) error {
-	switch fh.opcode {
-	case ContinuationFrame:
-		return ErrBadFrame
+// validateFrame checks the frame header against rfc6455#section-5.1, 5.4 and
+// 5.5. Clients mask every frame and servers none, rsv are the reserved bits
+// claimed by negotiated extensions and fragmented tells if a fragmented
+// message is being read.
+func validateFrame(fh FrameHeader, isServer bool, rsv byte, fragmented bool) error {
+	switch {
+	case !isControlFrameOpcode(fh.opcode) && !isDataFrameOpcode(fh.opcode) && fh.opcode != ContinuationFrame:
+		return ErrBadOpcode
+	case isServer && !fh.mask:
+		return ErrUnmaskedFrame
+	case !isServer && fh.mask:
+		return ErrMaskedFrame
+	case fh.RSV()&^rsv != 0:
+		return ErrBadRSV
+	case isControlFrameOpcode(fh.opcode) && fh.RSV() != 0:
+		// Extensions never apply to control frames
+		return ErrBadRSV
+	case fh.opcode == ContinuationFrame && fh.rsv1:
+		// permessage-deflate only marks the first frame, see rfc7692#section-6.1
+		return ErrContinuationRSV1
+	case isControlFrameOpcode(fh.opcode) && !fh.final:
+		return ErrFragmentedControl
+	case isControlFrameOpcode(fh.opcode) && fh.payloadLength > maxControlFramePayloadLength:
+		return ErrControlFrameLength
+	case fh.opcode == ContinuationFrame && !fragmented:
+		return ErrUnexpectedContinue
+	case isDataFrameOpcode(fh.opcode) && fragmented:
+		return ErrUnfinishedMessage
 	}
 
 	return nil
 }
 
+func isDataFrameOpcode(opcode byte) bool {
+	return opcode == TextMessage || opcode == BinaryMessage
+}
+
 func isFragmentedFrameStart(fin bool, opcode byte) bool {
 	return !fin && opcode != ContinuationFrame
 }
 
-func (fspec *FrameSpecHandler) receiveFrame() (fh FrameHeader, err error) {
-	conn := fspec.conn
-	p, err := conn.read(2)
+func (fspec *FrameSpecHandler) handleControlFrame(fh FrameHeader, reader io.Reader) error {
+	switch fh.opcode {
+	case CloseMessage:
+		return fspec.handleCloseMessage(fh, reader)
+	case PingMessage:
+		return fspec.handlePingMessage(fh, reader)
+	case PongMessage:
+		return fspec.handlePongMessage(fh, reader)
+	}
+
+	return nil
+}
+
+// readControl reads the payload of a control frame into the control scratch
+// space, it is valid until the next frame is read
+func (fspec *FrameSpecHandler) readControl(fh FrameHeader, reader io.Reader) ([]byte, error) {
+	payload := fspec.control[:fh.payloadLength]
+
+	_, err := io.ReadFull(reader, payload)
+
+	return payload, err
+}
+
+func (fspec *FrameSpecHandler) handlePongMessage(fh FrameHeader, reader io.Reader) error {
+	payload, err := fspec.readControl(fh, reader)
 
 	if err != nil {
-		return fh, err
+		return err
 	}
 
-	byte1 := p[0]
-	byte2 := p[1]
+	// Pongs keep the connection alive until the closing handshake
+	if ka := fspec.conn.keepAlive; ka != nil && fspec.getState() == stateOpen {
+		ka.pong(fspec.conn)
+	}
 
-	var maskingBytes []byte
+	return fspec.conn.pongHandler(payload)
+}
 
-	// 1: Control Bits
+func (fspec *FrameSpecHandler) handlePingMessage(fh FrameHeader, reader io.Reader) error {
+	payload, err := fspec.readControl(fh, reader)
 
-	final := byte1&finalBitMask != 0
-	frameType := byte1 & opCodeMask
-	mask := byte2&maskMask != 0
+	if err != nil {
+		return err
+	}
 
-	fh.final = final
-	fh.opcode = frameType
-	fh.mask = mask
+	return fspec.conn.pingHandler(payload)
+}
 
-	// 2: Payload Length
+// NextReader generate a reader for the next frame, the reader is valid until
+// the next call of NextReader or ReadMessage
+func (fspec *FrameSpecHandler) NextReader() (opcode byte, r io.Reader, err error) {
+	// Once Close reads the peer's close frame the connection is read after
+	// the closing handshake
+	if !fspec.startReading(readerApplication) {
+		<-fspec.closed
+	}
 
-	payloadLength := int64(byte2 & payloadLengthMask)
+	conn := fspec.conn
 
-	fh.payloadLength = payloadLength
+	// After the closing handshake only the peer's close is reported
 
-	switch payloadLength {
-	case 126:
-		pl, err := conn.read(2)
+	fspec.mu.Lock()
+	state, closeErr := fspec.state, fspec.closeErr
+	fspec.mu.Unlock()
 
-		if err != nil {
-			return fh, err
-		}
+	if state == stateClosed && closeErr != nil {
+		return CloseMessage, r, closeErr
+	}
 
-		payloadLength = int64(binary.BigEndian.Uint16(pl))
+	fh, err := fspec.nextFrame()
 
-		fh.payloadLength = payloadLength
-	case 127:
-		pl, err := conn.read(8)
+	if err != nil {
+		return fh.opcode, r, err
+	}
 
-		if err != nil {
-			return fh, err
-		}
+	var reader io.Reader = &fspec.payload
+
+	// 5 : Handle fragmented messages
+
+	if isFragmentedFrameStart(fh.final, fh.opcode) {
+		fspec.fragment = fragmentReader{R: reader, H: fspec}
+		reader = &fspec.fragment
+	}
 
-		payloadLength = int64(binary.BigEndian.Uint64(pl))
+	// Let the extensions transform the message in the reverse order of
+	// which they were applied by the sender
 
-		fh.payloadLength = payloadLength
+	if isDataFrameOpcode(fh.opcode) && len(conn.extensions) > 0 {
+		extensions := conn.extensions
 
-		if pl[0] > 127 {
-			return fh, errors.New("Most significant bit of payloadLength should be 0")
+		for i := len(extensions) - 1; i >= 0; i-- {
+			if reader, err = extensions[i].NewReader(fh, reader); err != nil {
+				return fh.opcode, r, err
+			}
 		}
+
+		// Extensions can make the message larger than its frames
+		if conn.readLimit > 0 {
+			reader = &limitReader{fspec, reader, conn.readLimit}
+		}
+	}
+
+	// Text messages must be valid UTF-8 across all of their fragments
+
+	if fh.opcode == TextMessage {
+		fspec.text = utf8Reader{fspec: fspec, r: reader}
+		reader = &fspec.text
 	}
 
-	// 3: Mask Bits
+	return fh.opcode, reader, err
+}
 
-	if mask {
-		maskingBytes, err = conn.read(4)
+// startReading claims the reading of the connection for reader, it reports
+// false when the connection is read by the other reader. The application
+// keeps the reading once it started, so Close never reads concurrently with
+// a reader loop even between its reads.
+func (fspec *FrameSpecHandler) startReading(reader int32) bool {
+	return atomic.CompareAndSwapInt32(&fspec.reader, readerNone, reader) || atomic.LoadInt32(&fspec.reader) == reader
+}
+
+// nextFrame reads frames until the next data frame and returns its header,
+// control frames are handled here and not exposed to the library's users.
+// fspec.payload reads the payload of the returned frame.
+func (fspec *FrameSpecHandler) nextFrame() (fh FrameHeader, err error) {
+	conn := fspec.conn
 
-		var maskBytes [4]byte
-		copy(maskBytes[:], maskingBytes[0:4])
+	for {
+		// 1 : Receive the frame header
+		fh, err = readFrameHeader(conn.brw, fspec.header[:])
 
-		fh.maskBytes = maskBytes
+		// A payload length that is not minimally encoded is a framing violation
+		if err == ErrBadFrame {
+			return fh, fspec.failConnection(CloseStatusProtocolError, err.Error(), err)
+		}
 
 		if err != nil {
-			return fh, err
+			return fh, fspec.abnormalClosure(err)
 		}
 
-	}
+		// 2 : Check if the frame header is valid, reserved bits must be 0 unless
+		// claimed by a negotiated extension see rfc6455#section-5.2
 
-	// 4: Return everything
+		if err := validateFrame(fh, conn.isServer, conn.rsv, conn.readFragmented); err != nil {
+			return fh, fspec.failConnection(CloseStatusProtocolError, err.Error(), err)
+		}
 
-	return fh, nil
-}
+		// Enforce the read limit on the message before reading its payload
 
-func (fspec *FrameSpecHandler) handleControlFrame(fh FrameHeader, reader io.Reader) error {
-	conn := fspec.conn
-	switch fh.opcode {
-	case CloseMessage:
-		conn.receivedClose = true
-		return fspec.handleCloseMessage(fh, reader)
-	case PingMessage:
-		return fspec.handlePingMessage(fh, reader)
-	case PongMessage:
-		return fspec.handlePongMessage(fh, reader)
-	}
+		if !isControlFrameOpcode(fh.opcode) {
+			if fh.opcode != ContinuationFrame {
+				conn.readLength = 0
+			}
 
-	return nil
-}
+			if conn.readLimit > 0 && fh.payloadLength > conn.readLimit-conn.readLength {
+				return fh, fspec.failConnection(CloseStatusTooBigData, "message too big", &ReadLimitError{conn.readLimit})
+			}
 
-func (fspec *FrameSpecHandler) handlePongMessage(fh FrameHeader, reader io.Reader) error {
-	log.Println("Received pong message, continue")
-	return nil
-}
+			conn.readLength += fh.payloadLength
+			conn.readFragmented = !fh.final
+		}
 
-func (fspec *FrameSpecHandler) handlePingMessage(fh FrameHeader, reader io.Reader) error {
-	log.Println("Received ping message, now sending pong")
-	payload, err := ioutil.ReadAll(reader)
+		// 3 : Create a reader for the payload
 
-	if err != nil {
-		return err
+		fspec.payload.reset(conn.brw, fh, &fspec.masked)
+
+		// 4 : Handle control frames
+
+		if !isControlFrameOpcode(fh.opcode) {
+			break
+		}
+
+		if err := fspec.handleControlFrame(fh, &fspec.payload); err != nil {
+			return fh, err
+		}
 	}
 
-	err = fspec.WriteMessage(PongMessage, payload)
+	return fh, nil
+}
 
-	return err
+// NextWriter returns a writer for a data message of unknown length, the
+// message is sent in frames of the connection's write frame size and the
+// final frame is sent on Close
+func (fspec *FrameSpecHandler) NextWriter(opcode byte) (w io.WriteCloser, err error) {
+	return fspec.nextWriter(opcode, fspec.conn.writeFrameSize)
 }
 
-func (fspec *FrameSpecHandler) handleCloseMessage(fh FrameHeader, reader io.Reader) error {
-	message, err := ioutil.ReadAll(reader)
+func (fspec *FrameSpecHandler) nextWriter(opcode byte, frameSize int) (w io.WriteCloser, err error) {
+	conn := fspec.conn
 
-	if err != nil {
-		return err
+	if !isDataFrameOpcode(opcode) {
+		return nil, errBadWriteOpcode
 	}
 
-	// The first two bytes are an unsigned integer containing the
-	// error code that explains why the socket was closed
-	statusCode := binary.BigEndian.Uint16(message[0:2])
-	statusMsg := string(message[2:])
+	if fspec.getState() != stateOpen {
+		return nil, ErrCloseSent
+	}
 
-	log.Println("Received CLOSE opcode with status:", statusCode, statusMsg)
+	if frameSize <= 0 {
+		frameSize = defaultWriteFrameSize
+	}
 
-	// After reading the payload we send a close message to the client
-	// in case we haven't already sent this.
+	fspec.messageMu.Lock()
 
-	if fspec.conn.sentClose {
-		log.Println("Already sent a close message, not sending again")
-		return errors.New("Received close message")
+	mw := &messageWriter{
+		fspec:  fspec,
+		header: NewFrameHeader(false, opcode, !conn.isServer, [4]byte{}, 0),
+		buf:    make([]byte, 0, frameSize),
 	}
 
-	err = fspec.CloseConnection(int(statusCode), "Too bad man")
+	// Let the negotiated extensions transform the message, the first
+	// extension is applied first
 
-	if err == nil {
-		fspec.conn.sentClose = true
-		return err
+	w = mw
+
+	for i := len(conn.extensions) - 1; i >= 0; i-- {
+		if w, err = conn.extensions[i].NewWriter(&mw.header, w); err != nil {
+			fspec.messageMu.Unlock()
+			return nil, err
+		}
 	}
 
-	return errors.New("Received close message")
+	return w, nil
 }
 
-// NextReader generate a reader for the next frame
-func (fspec *FrameSpecHandler) NextReader() (opcode byte, r io.Reader, err error) {
-	// 1 : Receive the frame header
-	fh, err := fspec.receiveFrame()
+// writeFrame writes the frame header and payload to the connection, a masked
+// frame gets a new masking key. Frames of concurrent writers are never
+// interleaved. Once the close frame is written every frame fails with
+// ErrCloseSent.
+//
+// The frame is assembled and masked in a pooled buffer, so the payload is left
+// unchanged. With a write buffer pool the frame is written to the network
+// connection directly and the connection holds no buffer between frames.
+func (fspec *FrameSpecHandler) writeFrame(fh FrameHeader, payload []byte) (err error) {
+	conn := fspec.conn
 
-	if err != nil {
-		return fh.opcode, r, err
+	fspec.writeMu.Lock()
+	defer fspec.writeMu.Unlock()
+
+	// Nothing may follow our close frame, see rfc6455#section-5.5.1
+
+	if fspec.closeSent {
+		return ErrCloseSent
 	}
 
-	// 2 : Check if the frame header is valid
+	fspec.closeSent = fh.opcode == CloseMessage
 
-	if err := isBadFrame(fh); err != nil {
-		// TODO: close the connection
+	if fh.mask {
+		if fh.maskBytes, err = fspec.maskKeys.key(); err != nil {
+			return err
+		}
+	}
 
-		return fh.opcode, r, err
+	var pool BufferPool = &maskBufferPool
+	var w io.Writer = conn.brw
+
+	if conn.writePool != nil {
+		pool, w = conn.writePool, conn.rwc
 	}
 
-	// 3 : Create a reader for the payload
+	buf := getWriteBuffer(pool, conn.writeBufferSize)
+	defer pool.Put(buf)
 
-	reader := io.LimitReader(fspec.conn.brw, fh.payloadLength)
+	// 1 : Fill the buffer with the header and as much payload as fits
 
-	// 4 : Handle control frames
+	b := AppendFrameHeader((*buf)[:0], fh)
 
-	if isControlFrameOpcode(fh.opcode) {
-		if err := fspec.handleControlFrame(fh, reader); err != nil {
-			return fh.opcode, r, err
+	for offset := 0; ; {
+		n := copy(b[len(b):cap(b)], payload[offset:])
+
+		if fh.mask {
+			mask(offset, fh.maskBytes, b[len(b):len(b)+n])
 		}
 
-		// Control frames are not exposed to the libraries users
-		// we thus continue by reading the next frame
-		return fspec.NextReader()
-	}
+		b = b[:len(b)+n]
+		offset += n
 
-	// 5 : Handle fragmented messages
+		// 2 : Write the buffer until the payload is written completely
 
-	if isFragmentedFrameStart(fh.final, fh.opcode) {
-		reader = &fragmentReader{reader, fspec}
-	}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+
+		if offset == len(payload) {
+			break
+		}
 
-	// 6 : Log & Return
+		b = b[:0]
+	}
 
-	log.Println("Receiving Frame", fh.opcode, fh.payloadLength)
+	if conn.writePool != nil {
+		return nil
+	}
 
-	return fh.opcode, reader, err
+	// Write the frame from the buffer to the connection
+	return conn.Flush()
 }
 
-// NextWriter write the frameheader to the conn and return a masked writer
-func (fspec *FrameSpecHandler) NextWriter(opcode byte, payloadLength int64) (w io.Writer, err error) {
+// writeControl sends a control frame, control frames are never fragmented
+func (fspec *FrameSpecHandler) writeControl(opcode byte, b []byte) error {
 	conn := fspec.conn
-	fh := NewFrameHeader(false, opcode, !conn.isServer, conn.mask, payloadLength)
-
-	_, err = conn.brw.Write(fh.toByteSlice())
 
-	if err != nil {
-		return w, err
+	if len(b) > maxControlFramePayloadLength {
+		return errControlFrameTooLarge
 	}
 
-	err = conn.brw.Flush()
-
-	if err != nil {
-		return w, err
-	}
+	fh := NewFrameHeader(true, opcode, !conn.isServer, [4]byte{}, int64(len(b)))
 
-	return NewMaskedWriter(conn.brw, fh.maskBytes), nil
+	return fspec.writeFrame(fh, b)
 }
 
-// ReadMessage read all bytes in payload using ioutil.ReadAll
+// ReadMessage reads the next message into a new slice
 func (fspec *FrameSpecHandler) ReadMessage() (opcode byte, message []byte, err error) {
+	return fspec.ReadMessageInto(nil)
+}
+
+// ReadMessageInto reads the next message into dst, replacing its contents. The
+// message is returned in dst when it fits dst's capacity and in a larger slice
+// otherwise, reusing the returned slice lets a reader receive messages without
+// allocating.
+func (fspec *FrameSpecHandler) ReadMessageInto(dst []byte) (opcode byte, message []byte, err error) {
 	opcode, reader, err := fspec.NextReader()
 
 	if err != nil {
-		return 0, message, err
+		return 0, dst[:0], err
 	}
 
-	message, err = ioutil.ReadAll(reader)
+	message, err = fspec.readAll(dst[:0], reader)
 
-	return opcode, message, err
+	return opcode, message, fspec.abnormalClosure(err)
 }
 
-// WriteMessage write all bytes in payload to writer
+// readAll appends everything read from reader to b, b only grows when the
+// message does not fit its capacity
+func (fspec *FrameSpecHandler) readAll(b []byte, reader io.Reader) ([]byte, error) {
+	for {
+		if len(b) == cap(b) {
+			// A full buffer only grows when the message continues
+			n, err := reader.Read(fspec.probe[:])
+			b = append(b, fspec.probe[:n]...)
+
+			if err == io.EOF {
+				return b, nil
+			}
+
+			if err != nil {
+				return b, err
+			}
+
+			continue
+		}
+
+		n, err := reader.Read(b[len(b):cap(b)])
+		b = b[:len(b)+n]
+
+		if err == io.EOF {
+			return b, nil
+		}
+
+		if err != nil {
+			return b, err
+		}
+	}
+}
+
+// WriteMessage write all bytes in payload to writer, the message is sent in
+// a single frame unless an extension makes it larger than b
 func (fspec *FrameSpecHandler) WriteMessage(opcode byte, b []byte) (err error) {
-	writer, err := fspec.NextWriter(opcode, int64(len(b)))
+	conn := fspec.conn
 
-	if err != nil {
-		return err
+	if opcode == TextMessage && conn.writeValidateUTF8 && !utf8.Valid(b) {
+		return ErrInvalidUTF8
 	}
 
-	_, err = writer.Write(b)
+	// Nothing may follow our close frame, see rfc6455#section-5.5.1
 
-	if err != nil {
-		return err
+	if opcode == CloseMessage {
+		return fspec.writeClose(b)
 	}
 
-	// Write the message from the buffer to the connection
-	fspec.conn.Flush()
+	if fspec.getState() != stateOpen {
+		return ErrCloseSent
+	}
 
-	return nil
-}
+	if isControlFrameOpcode(opcode) {
+		return fspec.writeControl(opcode, b)
+	}
+
+	// Without extensions the message is written as is in a single frame
+
+	if len(conn.extensions) == 0 && isDataFrameOpcode(opcode) {
+		fspec.messageMu.Lock()
+		defer fspec.messageMu.Unlock()
+
+		return fspec.writeFrame(NewFrameHeader(true, opcode, !conn.isServer, [4]byte{}, int64(len(b))), b)
+	}
+
+	frameSize := conn.writeFrameSize
+
+	if len(b) > frameSize {
+		frameSize = len(b)
+	}
+
+	w, err := fspec.nextWriter(opcode, frameSize)
 
-// CloseConnection sends the CloseMessage opcode to the receiver
-func (fspec *FrameSpecHandler) CloseConnection(statusCode int, statusMessage string) (err error) {
-	payload := make([]byte, 2)
-	msg := []byte(statusMessage)
+	if err != nil {
+		return err
+	}
 
-	binary.BigEndian.PutUint16(payload, uint16(statusCode))
-	payload = append(payload, msg...)
+	if _, err = w.Write(b); err != nil {
+		w.Close()
+		return err
+	}
 
-	return fspec.WriteMessage(CloseMessage, payload)
+	return w.Close()
 }
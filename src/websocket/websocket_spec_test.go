@@ -0,0 +1,198 @@
+package websocket
+
+import (
+	"bytes"
+	"net/http"
+	"runtime/debug"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateFrame(t *testing.T) {
+	frame := func(final bool, opcode byte, length int64) FrameHeader {
+		return NewFrameHeader(final, opcode, false, [4]byte{}, length)
+	}
+
+	compressed := frame(true, TextMessage, 0)
+	compressed.SetRSV(RSV1)
+
+	compressedContinuation := frame(true, ContinuationFrame, 0)
+	compressedContinuation.SetRSV(RSV1)
+
+	compressedPing := frame(true, PingMessage, 0)
+	compressedPing.SetRSV(RSV1)
+
+	cases := []struct {
+		name       string
+		fh         FrameHeader
+		rsv        byte
+		fragmented bool
+		expected   error
+	}{
+		{"text", frame(true, TextMessage, 10), 0, false, nil},
+		{"fragment start", frame(false, BinaryMessage, 10), 0, false, nil},
+		{"continuation", frame(true, ContinuationFrame, 10), 0, true, nil},
+		{"ping between fragments", frame(true, PingMessage, 125), 0, true, nil},
+		{"compressed", compressed, RSV1, false, nil},
+		{"opcode 3", frame(true, 3, 0), 0, false, ErrBadOpcode},
+		{"opcode 7", frame(true, 7, 0), 0, false, ErrBadOpcode},
+		{"opcode 11", frame(true, 11, 0), 0, false, ErrBadOpcode},
+		{"opcode 15", frame(true, 15, 0), 0, false, ErrBadOpcode},
+		{"unclaimed rsv", compressed, 0, false, ErrBadRSV},
+		{"rsv on control frame", compressedPing, RSV1, false, ErrBadRSV},
+		{"rsv1 on continuation", compressedContinuation, RSV1, true, ErrContinuationRSV1},
+		{"fragmented ping", frame(false, PingMessage, 0), 0, false, ErrFragmentedControl},
+		{"fragmented close", frame(false, CloseMessage, 0), 0, true, ErrFragmentedControl},
+		{"long pong", frame(true, PongMessage, 126), 0, false, ErrControlFrameLength},
+		{"orphan continuation", frame(true, ContinuationFrame, 0), 0, false, ErrUnexpectedContinue},
+		{"text inside message", frame(true, TextMessage, 0), 0, true, ErrUnfinishedMessage},
+		{"binary inside message", frame(false, BinaryMessage, 0), 0, true, ErrUnfinishedMessage},
+	}
+
+	// The cases are frames read by a client
+	for _, c := range cases {
+		assert.Equal(t, c.expected, validateFrame(c.fh, false, c.rsv, c.fragmented), c.name)
+	}
+
+	// Servers only accept masked frames and clients only unmasked frames
+	masked := NewFrameHeader(true, TextMessage, true, [4]byte{1, 2, 3, 4}, 0)
+
+	assert.Nil(t, validateFrame(masked, true, 0, false))
+	assert.Equal(t, ErrMaskedFrame, validateFrame(masked, false, 0, false))
+	assert.Equal(t, ErrUnmaskedFrame, validateFrame(frame(true, TextMessage, 0), true, 0, false))
+}
+
+func TestNextReaderFailsOnProtocolError(t *testing.T) {
+	cases := map[string][]byte{
+		"unknown opcode":      {0x83, 0x00},
+		"fragmented ping":     {0x09, 0x00},
+		"long ping":           append([]byte{0x89, 0x7e, 0x00, 0x7e}, make([]byte, 126)...),
+		"new message inside":  {0x01, 0x01, 'a', 0x81, 0x01, 'b'},
+		"16 bit short length": append([]byte{0x82, 0x7e, 0x00, 0x05}, make([]byte, 5)...),
+		"64 bit short length": append([]byte{0x82, 0x7f, 0, 0, 0, 0, 0, 0, 0x01, 0x00}, make([]byte, 256)...),
+		"64 bit length msb":   {0x82, 0x7f, 0x80, 0, 0, 0, 0, 0, 0, 0},
+	}
+
+	for name, frames := range cases {
+		src, ws, err := wsPipe(nil)
+
+		assert.Nil(t, err)
+
+		go src.Write(frames)
+
+		status := make(chan int, 1)
+
+		go func() {
+			status <- closeStatus(t, src)
+		}()
+
+		_, _, err = ws.Receive()
+
+		_, ok := err.(*ProtocolError)
+
+		assert.True(t, ok, "Expected a protocol error", name, err)
+		assert.Equal(t, CloseStatusProtocolError, <-status, name)
+	}
+}
+
+func TestMaskingRules(t *testing.T) {
+	// A server fails unmasked frames
+	src, server, err := wsPipe(&http.Request{})
+
+	assert.Nil(t, err)
+
+	go writeTestFrame(src, false, true, TextMessage, []byte("hello"))
+
+	status := make(chan int, 1)
+
+	go func() {
+		status <- closeStatus(t, src)
+	}()
+
+	_, _, err = server.Receive()
+
+	assert.Equal(t, ErrUnmaskedFrame, err)
+	assert.Equal(t, CloseStatusProtocolError, <-status)
+
+	// A client fails masked frames
+	src, client, err := wsPipe(nil)
+
+	assert.Nil(t, err)
+
+	go writeTestFrame(src, true, true, TextMessage, []byte("hello"))
+
+	go func() {
+		status <- closeStatus(t, src)
+	}()
+
+	_, _, err = client.Receive()
+
+	assert.Equal(t, ErrMaskedFrame, err)
+	assert.Equal(t, CloseStatusProtocolError, <-status)
+}
+
+func TestMaskKeyPerFrame(t *testing.T) {
+	src, ws, err := wsPipe(nil)
+
+	assert.Nil(t, err)
+	assert.Nil(t, ws.SetWriteFrameSize(1))
+
+	go func() {
+		ws.Send(PingMessage, []byte("ping"))
+		ws.Send(TextMessage, []byte("one"))
+
+		w, _ := ws.NextWriter(TextMessage)
+		w.Write([]byte("abc"))
+		w.Close()
+	}()
+
+	var frames []rawFrame
+
+	for len(frames) < 5 {
+		message, err := readRawFrames(src)
+
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		frames = append(frames, message...)
+	}
+
+	assert.Equal(t, "ping", string(frames[0].payload))
+	assert.Equal(t, "one", string(frames[1].payload))
+
+	// Every frame is masked with its own key
+	keys := map[[4]byte]bool{}
+
+	for _, frame := range frames {
+		assert.True(t, frame.header.mask)
+		keys[frame.header.maskBytes] = true
+	}
+
+	assert.Equal(t, len(frames), len(keys), "Expected a new masking key for every frame")
+}
+
+func TestNextReaderManyControlFrames(t *testing.T) {
+	src, ws, err := wsPipe(nil)
+
+	assert.Nil(t, err)
+
+	// Handling control frames must not grow the stack with their number
+	defer debug.SetMaxStack(debug.SetMaxStack(1 << 20))
+
+	var frames bytes.Buffer
+
+	for i := 0; i < 100000; i++ {
+		writeTestFrame(&frames, false, true, PongMessage, nil)
+	}
+
+	writeTestFrame(&frames, false, true, TextMessage, []byte("after pongs"))
+
+	go src.Write(frames.Bytes())
+
+	_, message, err := ws.Receive()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "after pongs", string(message))
+}